@@ -0,0 +1,31 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminMiddleware restricts the endpoints to the operators of Doorman, who must
+// provide the admin token in the Authorization request header (Bearer scheme).
+// The endpoints are disabled if no token is configured.
+func AdminMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "Admin endpoints are disabled",
+			})
+			return
+		}
+		provided := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message": "Invalid admin token",
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminMiddleware(t *testing.T) {
+	var cases = []struct {
+		token         string
+		authorization string
+		status        int
+	}{
+		// Disabled.
+		{"", "", http.StatusForbidden},
+		{"", "Bearer ", http.StatusForbidden},
+		// Invalid.
+		{"s3cr3t", "", http.StatusUnauthorized},
+		{"s3cr3t", "Bearer secret", http.StatusUnauthorized},
+		{"s3cr3t", "s3cr3t-and-more", http.StatusUnauthorized},
+		// Valid.
+		{"s3cr3t", "Bearer s3cr3t", http.StatusOK},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/principals", nil)
+		c.Request.Header.Set("Authorization", test.authorization)
+
+		AdminMiddleware(test.token)(c)
+
+		assert.Equal(t, test.status != http.StatusOK, c.IsAborted(), test.authorization)
+		if c.IsAborted() {
+			assert.Equal(t, test.status, w.Code, test.authorization)
+		}
+	}
+}
@@ -1,12 +1,27 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+
 	"github.com/mozilla/doorman/doorman"
 )
 
+// MaxBatchSize is the maximum number of requests in a batch (each of them may fetch
+// resource attributes and is written in the audit log).
+const MaxBatchSize = 100
+
+// batchRequest is the body of batch authorization requests.
+type batchRequest struct {
+	// Principals are only accepted when authentication is disabled.
+	Principals doorman.Principals
+	// Requests are decoded individually in order to report errors per item.
+	Requests []json.RawMessage
+}
+
 func allowedHandler(c *gin.Context) {
 	if c.Request.ContentLength == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -23,48 +38,162 @@ func allowedHandler(c *gin.Context) {
 		return
 	}
 
-	// Is authentication verification enable for this service?
-	// If disabled (like in tests), principals can be posted in JSON.
-	principals, ok := c.Get(PrincipalsContextKey)
-	if ok {
-		if len(r.Principals) > 0 {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "cannot submit principals with authentication enabled",
-			})
-			return
-		}
-		r.Principals = principals.(doorman.Principals)
-	} else {
-		if len(r.Principals) == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "missing principals",
-			})
-			return
-		}
+	principals, err := requestPrincipals(c, r.Principals)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
 	}
 
 	d := c.MustGet(DoormanContextKey).(doorman.Doorman)
+
 	service := c.Request.Header.Get("Origin")
 
 	// Expand principals with local ones.
-	r.Principals = d.ExpandPrincipals(service, r.Principals)
-	// Expand principals with specified roles.
-	r.Principals = append(r.Principals, r.Roles()...)
+	r.Principals = d.ExpandPrincipals(service, principals)
+
+	decision := authorize(c, d, service, &r)
+
+	response := decisionResponse(decision)
+	response["principals"] = r.Principals
+	c.JSON(http.StatusOK, response)
+}
+
+func batchAllowedHandler(c *gin.Context) {
+	if c.Request.ContentLength == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Missing body",
+		})
+		return
+	}
+
+	var batch batchRequest
+	if err := c.BindJSON(&batch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(batch.Requests) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "missing requests",
+		})
+		return
+	}
+	if len(batch.Requests) > MaxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("too many requests (max: %d)", MaxBatchSize),
+		})
+		return
+	}
+
+	principals, err := requestPrincipals(c, batch.Principals)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	d := c.MustGet(DoormanContextKey).(doorman.Doorman)
+
+	service := c.Request.Header.Get("Origin")
+
+	// Expand principals with local ones, once for the whole batch.
+	principals = d.ExpandPrincipals(service, principals)
+
+	decisions := make([]gin.H, len(batch.Requests))
+	for i, raw := range batch.Requests {
+		var r doorman.Request
+		if err := json.Unmarshal(raw, &r); err != nil {
+			decisions[i] = gin.H{
+				"allowed": false,
+				"error":   err.Error(),
+			}
+			continue
+		}
+		if len(r.Principals) > 0 {
+			decisions[i] = gin.H{
+				"allowed": false,
+				"error":   "cannot submit principals in batch requests items",
+			}
+			continue
+		}
+		r.Principals = principals
+
+		decisions[i] = decisionResponse(authorize(c, d, service, &r))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"principals": principals,
+		"decisions":  decisions,
+	})
+}
 
-	// Force some context values (for Audit logger mainly)
-	// XXX: using the context field to pass custom values on *ladon.Request
-	// for audit logging is not very elegant.
+// requestPrincipals returns the principals of the current user.
+// Is authentication verification enable for this service?
+// If disabled (like in tests), principals can be posted in JSON.
+func requestPrincipals(c *gin.Context, posted doorman.Principals) (doorman.Principals, error) {
+	principals, ok := c.Get(PrincipalsContextKey)
+	if ok {
+		if len(posted) > 0 {
+			return nil, fmt.Errorf("cannot submit principals with authentication enabled")
+		}
+		return principals.(doorman.Principals), nil
+	}
+	if len(posted) == 0 {
+		return nil, fmt.Errorf("missing principals")
+	}
+	return posted, nil
+}
+
+// authorize prepares the request and checks it against the service policies.
+func authorize(c *gin.Context, d doorman.Doorman, service string, r *doorman.Request) *doorman.Decision {
+	prepare(c, d, service, r)
+	return d.IsAllowed(service, r)
+}
+
+// prepare expands the request principals with their roles, forces some context values
+// and fills the metadata recorded by the audit logger.
+func prepare(c *gin.Context, d doorman.Doorman, service string, r *doorman.Request) {
+	// Expand principals with their roles (bound in the service or specified in context).
+	// (copy to avoid sharing the underlying array between batch items)
+	principals := append(doorman.Principals{}, r.Principals...)
+	r.Principals = append(principals, d.Roles(service, r)...)
+
+	// The remote address can be matched in policies conditions.
 	if r.Context == nil {
 		r.Context = doorman.Context{}
 	}
 	r.Context["remoteIP"] = c.Request.RemoteAddr
-	r.Context["_service"] = service
-	r.Context["_principals"] = r.Principals
 
-	allowed := d.IsAllowed(service, &r)
+	r.Metadata = doorman.Metadata{
+		RemoteAddr: c.Request.RemoteAddr,
+		RequestID:  c.GetString(RequestIDContextKey),
+		Subject:    c.GetString(SubjectContextKey),
+	}
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"allowed":    allowed,
-		"principals": r.Principals,
-	})
+// decisionResponse returns the decision fields of the response, to explain
+// why the request was allowed or denied.
+func decisionResponse(decision *doorman.Decision) gin.H {
+	response := gin.H{
+		"allowed":  decision.Allowed,
+		"policies": decision.Policies,
+		"reason":   decision.Reason,
+	}
+	if decision.Principal != "" {
+		response["principal"] = decision.Principal
+	}
+	if len(decision.Messages) > 0 {
+		response["messages"] = decision.Messages
+	}
+	if len(decision.Obligations) > 0 {
+		response["obligations"] = decision.Obligations
+	}
+	if len(decision.Advice) > 0 {
+		response["advice"] = decision.Advice
+	}
+	return response
 }
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -16,8 +19,14 @@ import (
 )
 
 type AllowedResponse struct {
-	Allowed    bool
-	Principals doorman.Principals
+	Allowed     bool
+	Principals  doorman.Principals
+	Policies    []string
+	Principal   string
+	Reason      string
+	Messages    []string
+	Obligations map[string]interface{}
+	Advice      map[string]interface{}
 }
 
 type ErrorResponse struct {
@@ -152,6 +161,10 @@ func TestAllowedHandler(t *testing.T) {
 	json.Unmarshal(w.Body.Bytes(), &resp)
 	assert.True(t, resp.Allowed)
 	assert.Equal(t, doorman.Principals{"userid:maria", "tag:admins"}, resp.Principals)
+	// Decision is explained.
+	assert.Equal(t, []string{"1"}, resp.Policies)
+	assert.Equal(t, "tag:admins", resp.Principal)
+	assert.Equal(t, "allowed", resp.Reason)
 }
 
 func TestAllowedHandlerRoles(t *testing.T) {
@@ -187,3 +200,243 @@ func TestAllowedHandlerRoles(t *testing.T) {
 	json.Unmarshal(w.Body.Bytes(), &resp)
 	assert.Equal(t, doorman.Principals{"userid:bob", "role:editor"}, resp.Principals)
 }
+
+func TestAllowedHandlerRoleBindings(t *testing.T) {
+	var resp AllowedResponse
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	d := doorman.NewDefaultLadon()
+	err := d.LoadPolicies(doorman.ServicesConfig{
+		doorman.ServiceConfig{
+			Service: "https://sample.yaml",
+			RoleBindings: doorman.RoleBindings{
+				doorman.RoleBinding{
+					Principals: doorman.Principals{"userid:alice"},
+					Roles:      []string{"editor"},
+				},
+			},
+			ClientRoles: doorman.ClientRoles{
+				Disabled: true,
+			},
+		},
+	})
+	require.Nil(t, err)
+	c.Set(DoormanContextKey, d)
+
+	// Roles from context are ignored.
+	authzRequest := doorman.Request{
+		Principals: doorman.Principals{"userid:alice"},
+		Action:     "update",
+		Resource:   "pto",
+		Context: doorman.Context{
+			"roles": []string{"admin"},
+		},
+	}
+	post, _ := json.Marshal(authzRequest)
+	body := bytes.NewBuffer(post)
+	c.Request, _ = http.NewRequest("POST", "/allowed", body)
+	c.Request.Header.Set("Origin", "https://sample.yaml")
+
+	allowedHandler(c)
+
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, doorman.Principals{"userid:alice", "role:editor"}, resp.Principals)
+}
+
+func TestAllowedHandlerDecisionDetails(t *testing.T) {
+	var resp AllowedResponse
+
+	tmpfile, _ := ioutil.TempFile("", "")
+	defer os.Remove(tmpfile.Name()) // clean up
+	tmpfile.Write([]byte(`
+service: https://sample.yaml
+identityProvider:
+policies:
+  - id: contractors-no-delete
+    principals:
+      - group:contractors
+    actions:
+      - delete
+    resources:
+      - <.*>
+    effect: deny
+    reason: Contractors cannot delete records
+    advice:
+      contact: ops@mozilla.com
+  - id: employees-delete
+    principals:
+      - group:employees
+    actions:
+      - delete
+    resources:
+      - <.*>
+    effect: allow
+    obligations:
+      requireMFA: true
+      maskFields:
+        profile: [email]
+`))
+	tmpfile.Close()
+	configs, err := config.Load([]string{tmpfile.Name()})
+	require.Nil(t, err)
+	d := doorman.NewDefaultLadon()
+	require.Nil(t, d.LoadPolicies(configs))
+
+	for _, test := range []struct {
+		principal string
+		expected  AllowedResponse
+	}{
+		{"group:contractors", AllowedResponse{
+			Messages: []string{"Contractors cannot delete records"},
+			Advice:   map[string]interface{}{"contact": "ops@mozilla.com"},
+		}},
+		{"group:employees", AllowedResponse{
+			Allowed: true,
+			Obligations: map[string]interface{}{
+				"requireMFA": true,
+				"maskFields": map[string]interface{}{"profile": []interface{}{"email"}},
+			},
+		}},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set(DoormanContextKey, d)
+		body := bytes.NewBuffer([]byte(`{"principals": ["` + test.principal + `"], "action": "delete", "resource": "record"}`))
+		c.Request, _ = http.NewRequest("POST", "/allowed", body)
+		c.Request.Header.Set("Origin", "https://sample.yaml")
+
+		allowedHandler(c)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		resp = AllowedResponse{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, test.expected.Allowed, resp.Allowed)
+		assert.Equal(t, test.expected.Messages, resp.Messages)
+		assert.Equal(t, test.expected.Obligations, resp.Obligations)
+		assert.Equal(t, test.expected.Advice, resp.Advice)
+	}
+}
+
+type BatchDecision struct {
+	Allowed bool
+	Reason  string
+	Error   string
+}
+
+type BatchAllowedResponse struct {
+	Principals doorman.Principals
+	Decisions  []BatchDecision
+}
+
+func TestBatchAllowedHandlerBadRequest(t *testing.T) {
+	var errResp ErrorResponse
+
+	// Empty body
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/allowed/batch", nil)
+	batchAllowedHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	assert.Equal(t, errResp.Message, "Missing body")
+
+	// Missing requests
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	body := bytes.NewBuffer([]byte("{\"principals\":[\"userid:maria\"]}"))
+	c.Request, _ = http.NewRequest("POST", "/allowed/batch", body)
+	batchAllowedHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	assert.Equal(t, errResp.Message, "missing requests")
+
+	// Too many requests
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	items := strings.Repeat("{\"action\":\"update\"},", MaxBatchSize)
+	body = bytes.NewBuffer([]byte("{\"requests\":[" + items + "{\"action\":\"update\"}]}"))
+	c.Request, _ = http.NewRequest("POST", "/allowed/batch", body)
+	batchAllowedHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	assert.Equal(t, errResp.Message, "too many requests (max: 100)")
+
+	// Missing principals when AuthnMiddleware not enabled.
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	body = bytes.NewBuffer([]byte("{\"requests\":[{\"action\":\"update\"}]}"))
+	c.Request, _ = http.NewRequest("POST", "/allowed/batch", body)
+	batchAllowedHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	assert.Contains(t, errResp.Message, "missing principals")
+}
+
+func TestBatchAllowedHandler(t *testing.T) {
+	var resp BatchAllowedResponse
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	configs, err := config.Load([]string{"../sample.yaml"})
+	require.Nil(t, err)
+
+	d := doorman.NewDefaultLadon()
+	err = d.LoadPolicies(configs)
+	require.Nil(t, err)
+	c.Set(DoormanContextKey, d)
+
+	// Using principals from context (AuthnMiddleware)
+	c.Set(PrincipalsContextKey, doorman.Principals{"userid:bob"})
+
+	body := bytes.NewBuffer([]byte(`{
+	  "requests": [
+	    {"action": "wear", "resource": "ring", "context": {"roles": ["owner"], "owner": "role:owner"}},
+	    {"action": "wear", "resource": "ring", "context": {"owner": "role:owner"}},
+	    {"action": 42},
+	    {"action": "read", "principals": ["userid:maria"]},
+	    {"action": "read", "context": {"owner": "userid:bob"}}
+	  ]
+	}`))
+	c.Request, _ = http.NewRequest("POST", "/allowed/batch", body)
+	c.Request.Header.Set("Origin", "https://sample.yaml")
+
+	batchAllowedHandler(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, doorman.Principals{"userid:bob"}, resp.Principals)
+	require.Equal(t, 5, len(resp.Decisions))
+	// Roles are specific to each item.
+	assert.True(t, resp.Decisions[0].Allowed)
+	assert.False(t, resp.Decisions[1].Allowed)
+	assert.Equal(t, "no-match", resp.Decisions[1].Reason)
+	assert.Equal(t, "", resp.Decisions[1].Error)
+	// Invalid items carry their own error.
+	assert.False(t, resp.Decisions[2].Allowed)
+	assert.Contains(t, resp.Decisions[2].Error, "cannot unmarshal number")
+	assert.False(t, resp.Decisions[3].Allowed)
+	assert.Contains(t, resp.Decisions[3].Error, "cannot submit principals")
+	assert.True(t, resp.Decisions[4].Allowed)
+}
+
+func TestPrepareMetadata(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/allowed", nil)
+	c.Request.RemoteAddr = "10.0.0.1:4242"
+	c.Set(SubjectContextKey, "maria")
+	c.Set(RequestIDContextKey, "abc")
+
+	var r doorman.Request
+	// Metadata cannot be posted.
+	err := json.Unmarshal([]byte(`{"action": "read", "metadata": {"subject": "admin"}}`), &r)
+	require.Nil(t, err)
+
+	prepare(c, doorman.NewDefaultLadon(), "https://sample.yaml", &r)
+
+	assert.Equal(t, doorman.Metadata{RemoteAddr: "10.0.0.1:4242", RequestID: "abc", Subject: "maria"}, r.Metadata)
+	assert.Equal(t, doorman.Context{"remoteIP": "10.0.0.1:4242"}, r.Context)
+}
@@ -12,6 +12,8 @@ func SetupRoutes(r *gin.Engine, d doorman.Doorman) {
 	a := r.Group("")
 	a.Use(AuthnMiddleware(d))
 	a.POST("/allowed", allowedHandler)
+	a.POST("/allowed/batch", batchAllowedHandler)
+	a.POST("/permissions", permissionsHandler)
 
 	sources := d.ConfigSources()
 	r.POST("/__reload__", reloadHandler(sources))
@@ -22,3 +24,14 @@ func SetupRoutes(r *gin.Engine, d doorman.Doorman) {
 	r.GET("/__api__", YAMLAsJSONHandler("api/openapi.yaml"))
 	r.GET("/contribute.json", YAMLAsJSONHandler("api/contribute.yaml"))
 }
+
+// SetupAdminRoutes adds the HTTP endpoints reserved to operators (eg. access reviews,
+// relationship tuples, shadow policies divergences).
+// They require the admin token instead of the users authentication.
+func SetupAdminRoutes(r *gin.Engine, token string) {
+	a := r.Group("")
+	a.Use(AdminMiddleware(token))
+	a.POST("/principals", principalsHandler)
+	a.POST("/relations", relationsHandler)
+	a.GET("/__shadow__", shadowHandler)
+}
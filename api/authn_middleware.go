@@ -16,6 +16,9 @@ const DoormanContextKey string = "doorman"
 // PrincipalsContextKey is the Gin context key to obtain the current user principals.
 const PrincipalsContextKey string = "principals"
 
+// SubjectContextKey is the Gin context key to obtain the user ID of the authentication token.
+const SubjectContextKey string = "subject"
+
 // ContextMiddleware adds the Doorman instance to the Gin context.
 func ContextMiddleware(d doorman.Doorman) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -67,6 +70,7 @@ func AuthnMiddleware(d doorman.Doorman) gin.HandlerFunc {
 		principals := buildPrincipals(userInfo)
 
 		c.Set(PrincipalsContextKey, principals)
+		c.Set(SubjectContextKey, userInfo.ID)
 
 		c.Next()
 	}
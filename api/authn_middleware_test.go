@@ -97,3 +97,21 @@ func TestAuthnMiddleware(t *testing.T) {
 	principals, _ = c.Get(PrincipalsContextKey)
 	assert.Equal(t, doorman.Principals{"userid:ldap|user"}, principals)
 }
+
+func TestAuthnMiddlewareWithoutIdentityProvider(t *testing.T) {
+	d := doorman.NewDefaultLadon()
+	err := d.LoadPolicies(doorman.ServicesConfig{
+		doorman.ServiceConfig{Service: "https://noauth.com"},
+	})
+	require.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/get", nil)
+	c.Request.Header.Set("Origin", "https://noauth.com")
+	AuthnMiddleware(d)(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	_, ok := c.Get(PrincipalsContextKey)
+	assert.False(t, ok)
+}
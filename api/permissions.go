@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mozilla/doorman/doorman"
+)
+
+func permissionsHandler(c *gin.Context) {
+	var r doorman.Request
+	// The body is optional (ie. all permissions of the authenticated user).
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	principals, err := requestPrincipals(c, r.Principals)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	d := c.MustGet(DoormanContextKey).(doorman.Doorman)
+
+	service := c.Request.Header.Get("Origin")
+
+	// Expand principals with local ones.
+	r.Principals = d.ExpandPrincipals(service, principals)
+
+	prepare(c, d, service, &r)
+
+	c.JSON(http.StatusOK, gin.H{
+		"principals":  r.Principals,
+		"permissions": d.Permissions(service, &r),
+	})
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mozilla/doorman/config"
+	"github.com/mozilla/doorman/doorman"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type PermissionsResponse struct {
+	Principals  doorman.Principals
+	Permissions []doorman.Permission
+}
+
+func TestPermissionsHandlerBadRequest(t *testing.T) {
+	var errResp ErrorResponse
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set(DoormanContextKey, doorman.NewDefaultLadon())
+
+	// Missing principals
+	c.Request, _ = http.NewRequest("POST", "/permissions", nil)
+	permissionsHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	assert.Equal(t, "missing principals", errResp.Message)
+}
+
+func TestPermissionsHandler(t *testing.T) {
+	var resp PermissionsResponse
+
+	configs, err := config.Load([]string{"../sample.yaml"})
+	require.Nil(t, err)
+
+	d := doorman.NewDefaultLadon()
+	err = d.LoadPolicies(configs)
+	require.Nil(t, err)
+
+	// All permissions of the user.
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set(DoormanContextKey, d)
+	// Using principals from context (AuthnMiddleware)
+	c.Set(PrincipalsContextKey, doorman.Principals{"userid:maria"})
+	c.Request, _ = http.NewRequest("POST", "/permissions", nil)
+	c.Request.Header.Set("Origin", "https://sample.yaml")
+
+	permissionsHandler(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, doorman.Principals{"userid:maria", "tag:admins"}, resp.Principals)
+	assert.Equal(t, []doorman.Permission{
+		{Action: "update", Resource: "<.*>", Pattern: true},
+	}, resp.Permissions)
+
+	// Permissions on a resource, with roles from context.
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Set(DoormanContextKey, d)
+	body := bytes.NewBuffer([]byte(`{
+	  "principals": ["userid:bob"],
+	  "resource": "ring",
+	  "context": {"roles": ["owner"], "owner": "role:owner"}
+	}`))
+	c.Request, _ = http.NewRequest("POST", "/permissions", body)
+	c.Request.Header.Set("Origin", "https://sample.yaml")
+
+	permissionsHandler(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp = PermissionsResponse{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, doorman.Principals{"userid:bob", "role:owner"}, resp.Principals)
+	assert.Equal(t, []doorman.Permission{
+		{Action: "<.*>", Resource: "ring", Pattern: true},
+	}, resp.Permissions)
+}
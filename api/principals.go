@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mozilla/doorman/doorman"
+)
+
+func principalsHandler(c *gin.Context) {
+	if c.Request.ContentLength == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Missing body",
+		})
+		return
+	}
+
+	var r doorman.Request
+	if err := c.BindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(r.Principals) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "cannot submit principals in reverse lookups",
+		})
+		return
+	}
+
+	d := c.MustGet(DoormanContextKey).(doorman.Doorman)
+
+	service := c.Request.Header.Get("Origin")
+
+	c.JSON(http.StatusOK, gin.H{
+		"principals": d.AllowedPrincipals(service, &r),
+	})
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mozilla/doorman/config"
+	"github.com/mozilla/doorman/doorman"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type PrincipalsResponse struct {
+	Principals doorman.Principals
+}
+
+func TestPrincipalsHandlerBadRequest(t *testing.T) {
+	var errResp ErrorResponse
+
+	// Empty body
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/principals", nil)
+	principalsHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	assert.Equal(t, "Missing body", errResp.Message)
+
+	// Posted principals
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	body := bytes.NewBuffer([]byte(`{"principals": ["userid:maria"], "action": "read"}`))
+	c.Request, _ = http.NewRequest("POST", "/principals", body)
+	principalsHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	assert.Equal(t, "cannot submit principals in reverse lookups", errResp.Message)
+}
+
+func TestPrincipalsHandler(t *testing.T) {
+	var resp PrincipalsResponse
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	configs, err := config.Load([]string{"../sample.yaml"})
+	require.Nil(t, err)
+
+	d := doorman.NewDefaultLadon()
+	err = d.LoadPolicies(configs)
+	require.Nil(t, err)
+	c.Set(DoormanContextKey, d)
+
+	body := bytes.NewBuffer([]byte(`{"action": "update", "resource": "pto"}`))
+	c.Request, _ = http.NewRequest("POST", "/principals", body)
+	c.Request.Header.Set("Origin", "https://sample.yaml")
+
+	principalsHandler(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, doorman.Principals{"userid:foo", "tag:admins", "userid:maria"}, resp.Principals)
+}
+
+func TestPrincipalsRequiresAdminToken(t *testing.T) {
+	configs, err := config.Load([]string{"../sample.yaml"})
+	require.Nil(t, err)
+	d := doorman.NewDefaultLadon()
+	require.Nil(t, d.LoadPolicies(configs))
+
+	r := gin.New()
+	SetupRoutes(r, d)
+	SetupAdminRoutes(r, "s3cr3t")
+
+	post := func(authorization string) *httptest.ResponseRecorder {
+		body := bytes.NewBuffer([]byte(`{"action": "update", "resource": "pto"}`))
+		req, _ := http.NewRequest("POST", "/principals", body)
+		req.Header.Set("Origin", "https://sample.yaml")
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// End users cannot list the principals.
+	assert.Equal(t, http.StatusUnauthorized, post("").Code)
+	assert.Equal(t, http.StatusUnauthorized, post("Bearer f2457yu86yikhmbh").Code)
+
+	w := post("Bearer s3cr3t")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp PrincipalsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Contains(t, resp.Principals, "userid:maria")
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mozilla/doorman/doorman"
+)
+
+// RelationsRequest is the body of relationship tuples changes.
+type RelationsRequest struct {
+	Writes  []string `json:"writes"`
+	Deletes []string `json:"deletes"`
+}
+
+func relationsHandler(c *gin.Context) {
+	if c.Request.ContentLength == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Missing body",
+		})
+		return
+	}
+
+	var r RelationsRequest
+	if err := c.BindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	writes, err := parseTuples(r.Writes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	deletes, err := parseTuples(r.Deletes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	d := c.MustGet(DoormanContextKey).(doorman.Doorman)
+
+	service := c.Request.Header.Get("Origin")
+
+	if err := d.UpdateTuples(service, deletes, writes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"written": len(writes),
+		"deleted": len(deletes),
+	})
+}
+
+func parseTuples(strs []string) ([]doorman.Tuple, error) {
+	tuples := []doorman.Tuple{}
+	for _, s := range strs {
+		t, err := doorman.ParseTuple(s)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, t)
+	}
+	return tuples, nil
+}
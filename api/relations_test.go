@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mozilla/doorman/config"
+	"github.com/mozilla/doorman/doorman"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func relationsContext(d doorman.Doorman, body string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set(DoormanContextKey, d)
+	c.Request, _ = http.NewRequest("POST", "/relations", bytes.NewBuffer([]byte(body)))
+	c.Request.Header.Set("Origin", "https://sample.yaml")
+	return c, w
+}
+
+func TestRelationsHandlerBadRequest(t *testing.T) {
+	var errResp ErrorResponse
+
+	d := doorman.NewDefaultLadon()
+
+	// Empty body
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/relations", nil)
+	relationsHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	assert.Equal(t, "Missing body", errResp.Message)
+
+	// Invalid tuple
+	c, w = relationsContext(d, `{"writes": ["document:42"]}`)
+	relationsHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	assert.Equal(t, "invalid tuple \"document:42\"", errResp.Message)
+
+	// Unknown service
+	c, w = relationsContext(d, `{"deletes": ["document:42#editor@userid:alice"]}`)
+	relationsHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	assert.Equal(t, "unknown service \"https://sample.yaml\"", errResp.Message)
+}
+
+func TestRelationsHandler(t *testing.T) {
+	configs, err := config.Load([]string{"../sample.yaml"})
+	require.Nil(t, err)
+	d := doorman.NewDefaultLadon()
+	err = d.LoadPolicies(configs)
+	require.Nil(t, err)
+
+	c, w := relationsContext(d, `{"writes": ["document:42#editor@userid:alice", "document:42#editor@userid:bob"]}`)
+	relationsHandler(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"deleted":0,"written":2}`, w.Body.String())
+
+	c, w = relationsContext(d, `{"deletes": ["document:42#editor@userid:bob"]}`)
+	relationsHandler(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"deleted":1,"written":0}`, w.Body.String())
+}
+
+func TestRelationsAdminRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	SetupRoutes(r, doorman.NewDefaultLadon())
+	SetupAdminRoutes(r, "s3cr3t")
+
+	// End users cannot change the relationships.
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/relations", bytes.NewBuffer([]byte(`{"writes": ["document:42#editor@userid:mallory"]}`)))
+	req.Header.Set("Origin", "https://sample.yaml")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -6,11 +6,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/mozilla/doorman/config"
 	"github.com/mozilla/doorman/doorman"
 )
 
@@ -86,3 +89,37 @@ policies:
 
 	assert.Equal(t, w.Code, 500)
 }
+
+func TestReloadUnderLoad(t *testing.T) {
+	configs, err := config.Load([]string{"../sample.yaml"})
+	require.Nil(t, err)
+	d := doorman.NewDefaultLadon()
+	err = d.LoadPolicies(configs)
+	require.Nil(t, err)
+
+	r := gin.New()
+	SetupRoutes(r, d)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				decision := d.IsAllowed("https://sample.yaml", &doorman.Request{
+					Principals: doorman.Principals{"userid:foo"},
+					Action:     "update",
+				})
+				assert.True(t, decision.Allowed)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				w := performRequest(r, "POST", "/__reload__", nil)
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+}
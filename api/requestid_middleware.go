@@ -0,0 +1,52 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader is the HTTP header that carries the request ID.
+const RequestIDHeader string = "X-Request-Id"
+
+// RequestIDContextKey is the Gin context key to obtain the current request ID.
+const RequestIDContextKey string = "requestID"
+
+// maxRequestIDLength is the maximum length of the request IDs taken from headers.
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware identifies each request, in order to correlate the log entries.
+// The ID is taken from the X-Request-Id request header (eg. set by a load balancer)
+// or generated, and is returned in the response headers.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rid := c.Request.Header.Get(RequestIDHeader)
+		if !validRequestID(rid) {
+			rid = newRequestID()
+		}
+		c.Set(RequestIDContextKey, rid)
+		c.Header(RequestIDHeader, rid)
+		c.Next()
+	}
+}
+
+// validRequestID returns true if the ID is short and printable (to be safely logged).
+func validRequestID(rid string) bool {
+	if rid == "" || len(rid) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range rid {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random ID of 32 hexadecimal characters.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
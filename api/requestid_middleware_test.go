@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	handler := RequestIDMiddleware()
+
+	// Taken from request header.
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/get", nil)
+	c.Request.Header.Set(RequestIDHeader, "abc-123")
+	handler(c)
+	assert.Equal(t, "abc-123", c.GetString(RequestIDContextKey))
+	assert.Equal(t, "abc-123", w.Header().Get(RequestIDHeader))
+
+	// Generated if missing or invalid.
+	for _, header := range []string{"", "a b", "a\nb", strings.Repeat("a", 200)} {
+		w = httptest.NewRecorder()
+		c, _ = gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/get", nil)
+		c.Request.Header.Set(RequestIDHeader, header)
+		handler(c)
+		rid := c.GetString(RequestIDContextKey)
+		assert.Len(t, rid, 32)
+		assert.Equal(t, rid, w.Header().Get(RequestIDHeader))
+	}
+
+	// Unique.
+	assert.NotEqual(t, newRequestID(), newRequestID())
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mozilla/doorman/doorman"
+)
+
+func shadowHandler(c *gin.Context) {
+	d := c.MustGet(DoormanContextKey).(doorman.Doorman)
+
+	c.JSON(http.StatusOK, d.ShadowStats())
+}
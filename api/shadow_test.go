@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mozilla/doorman/doorman"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadowHandler(t *testing.T) {
+	d := doorman.NewDefaultLadon()
+	err := d.LoadPolicies(doorman.ServicesConfig{
+		doorman.ServiceConfig{
+			Service: "a",
+			Policies: doorman.Policies{
+				doorman.Policy{
+					ID:         "readers",
+					Principals: doorman.Principals{"<.*>"},
+					Actions:    []string{"read"},
+					Resources:  []string{"<.*>"},
+					Effect:     "allow",
+					Mode:       doorman.ShadowMode,
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+	d.IsAllowed("a", &doorman.Request{Principals: doorman.Principals{"userid:a"}, Action: "read"})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set(DoormanContextKey, d)
+	c.Request, _ = http.NewRequest("GET", "/__shadow__", nil)
+	shadowHandler(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"a":{"divergences":1,"granted":1,"revoked":0,"policies":{"readers":1}}}`, w.Body.String())
+}
+
+func TestShadowAdminRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	SetupRoutes(r, doorman.NewDefaultLadon())
+	SetupAdminRoutes(r, "s3cr3t")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__shadow__", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mozilla/doorman/config"
+	"github.com/mozilla/doorman/doorman"
+)
+
+// commands are the command line subcommands (eg. doorman principals -service ...).
+var commands = map[string]func(args []string, out io.Writer) error{
+	"principals": principalsCommand,
+	"audit":      auditCommand,
+}
+
+// runCommand executes the specified subcommand.
+func runCommand(args []string, out io.Writer) error {
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return command(args[1:], out)
+}
+
+// loadDoorman loads the policies files from settings (policies are compiled once loaded).
+// The audit log is not set up (see setupAudit), the commands only read the policies.
+func loadDoorman() (*doorman.CompiledDoorman, error) {
+	configs, err := config.Load(settings.Sources)
+	if err != nil {
+		return nil, err
+	}
+	d := doorman.NewCompiledDoorman()
+	// Relationship tuples are kept in memory, unless a file is specified.
+	if settings.RelationsFile != "" {
+		store, err := doorman.NewFileTupleStore(settings.RelationsFile)
+		if err != nil {
+			return nil, err
+		}
+		d.SetTupleStore(store)
+	}
+	if err := d.LoadPolicies(configs); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// setupAudit instantiates the audit sinks, redaction rules and chain from settings.
+func setupAudit(d *doorman.CompiledDoorman) error {
+	sinks := []doorman.AuditSink{}
+	for _, location := range settings.AuditSinks {
+		sink, err := doorman.NewAuditSink(location)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	d.SetAuditSinks(sinks...)
+	redactor, err := loadRedactor()
+	if err != nil {
+		return err
+	}
+	d.SetAuditRedactor(redactor)
+	// Audit entries are chained if a signing key is specified.
+	if settings.AuditChainKey != "" {
+		key, err := doorman.LoadAuditSigningKey(settings.AuditChainKey)
+		if err != nil {
+			return err
+		}
+		d.SetAuditChain(doorman.NewAuditChain(key, doorman.DefaultAuditCheckpointInterval))
+	}
+	return nil
+}
+
+// loadRedactor parses the redaction rules of log entries from settings (nil if none).
+func loadRedactor() (*doorman.Redactor, error) {
+	if len(settings.AuditRedact) == 0 {
+		return nil, nil
+	}
+	return doorman.NewRedactor(settings.AuditRedact, []byte(settings.AuditHMACKey))
+}
+
+// principalsCommand prints the principals allowed to perform an action on a resource.
+func principalsCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("principals", flag.ContinueOnError)
+	flags.SetOutput(out)
+	service := flags.String("service", "", "service identifier (eg. https://api.service.org)")
+	action := flags.String("action", "", "action to perform")
+	resource := flags.String("resource", "", "resource to perform the action on")
+	context := flags.String("context", "", "request context as JSON (optional)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *service == "" || *action == "" || *resource == "" {
+		return fmt.Errorf("missing service, action or resource")
+	}
+
+	request := &doorman.Request{
+		Action:   *action,
+		Resource: *resource,
+	}
+	if *context != "" {
+		if err := json.Unmarshal([]byte(*context), &request.Context); err != nil {
+			return fmt.Errorf("invalid context: %s", err)
+		}
+	}
+
+	d, err := loadDoorman()
+	if err != nil {
+		return err
+	}
+	for _, principal := range d.AllowedPrincipals(*service, request) {
+		fmt.Fprintln(out, principal)
+	}
+	return nil
+}
+
+// auditCommand verifies the chain of audit log files (eg. doorman audit verify -key audit.pub audit.log.1 audit.log).
+func auditCommand(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return fmt.Errorf("unknown audit command (expected: verify)")
+	}
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	flags.SetOutput(out)
+	keyfile := flags.String("key", "", "Ed25519 public key file to check that every record is signed (optional)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("missing audit log files")
+	}
+
+	var key ed25519.PublicKey
+	if *keyfile != "" {
+		var err error
+		if key, err = doorman.LoadAuditVerifyKey(*keyfile); err != nil {
+			return err
+		}
+	}
+	// The files are verified as a single log (eg. rotated files, oldest first).
+	verifier := doorman.NewAuditVerifier(key)
+	for _, filename := range flags.Args() {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		err = verifier.Verify(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+	result, err := verifier.Result()
+	if err != nil {
+		return fmt.Errorf("%s: %s", flags.Arg(flags.NArg()-1), err)
+	}
+	fmt.Fprintf(out, "%d records, %d chains, %d checkpoints verified\n", result.Records, result.Chains, result.Checkpoints)
+	if key == nil {
+		fmt.Fprintln(out, "Checkpoints signatures were not verified (no key specified)")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommand(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := runCommand([]string{"unknown"}, &buf)
+	assert.Equal(t, "unknown command \"unknown\"", err.Error())
+}
+
+func TestPrincipalsCommand(t *testing.T) {
+	var buf bytes.Buffer
+
+	defer func(sources []string) { settings.Sources = sources }(settings.Sources)
+	settings.Sources = []string{"sample.yaml"}
+
+	// Missing arguments.
+	err := runCommand([]string{"principals", "-service", "https://sample.yaml"}, &buf)
+	assert.Equal(t, "missing service, action or resource", err.Error())
+
+	// Invalid context.
+	err = runCommand([]string{"principals", "-service", "https://sample.yaml", "-action", "update", "-resource", "pto", "-context", "{"}, &buf)
+	assert.Contains(t, err.Error(), "invalid context")
+
+	err = runCommand([]string{"principals", "-service", "https://sample.yaml", "-action", "update", "-resource", "pto"}, &buf)
+	require.Nil(t, err)
+	assert.Equal(t, "userid:foo\ntag:admins\nuserid:maria\n", buf.String())
+
+	// The audit log is not opened.
+	defer func(sinks []string) { settings.AuditSinks = sinks }(settings.AuditSinks)
+	settings.AuditSinks = []string{"unknown"}
+	buf.Reset()
+	err = runCommand([]string{"principals", "-service", "https://sample.yaml", "-action", "update", "-resource", "pto"}, &buf)
+	require.Nil(t, err)
+	assert.Equal(t, "userid:foo\ntag:admins\nuserid:maria\n", buf.String())
+
+	buf.Reset()
+	err = runCommand([]string{"principals", "-service", "https://sample.yaml", "-action", "update", "-resource", "pto", "-context", `{"planet": "mars"}`}, &buf)
+	require.Nil(t, err)
+	assert.Equal(t, "", buf.String())
+}
+
+func TestAuditCommand(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := runCommand([]string{"audit"}, &buf)
+	assert.Equal(t, "unknown audit command (expected: verify)", err.Error())
+
+	err = runCommand([]string{"audit", "verify"}, &buf)
+	assert.Equal(t, "missing audit log files", err.Error())
+
+	tmpfile, _ := ioutil.TempFile("", "")
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Write([]byte(`{"Type": "request.authorization", "Timestamp": 1, "Fields": {"seq": 1, "prevHash": ""}}
+{"Type": "request.authorization", "Timestamp": 2, "Fields": {"seq": 3, "prevHash": ""}}
+`))
+	tmpfile.Close()
+
+	err = runCommand([]string{"audit", "verify", tmpfile.Name()}, &buf)
+	assert.Equal(t, tmpfile.Name()+": broken link at line 2: seq 3 follows 1", err.Error())
+
+	ioutil.WriteFile(tmpfile.Name(), []byte(`{"Type": "request.authorization", "Timestamp": 1, "Fields": {"seq": 1, "prevHash": ""}}`), 0600)
+	buf.Reset()
+	err = runCommand([]string{"audit", "verify", tmpfile.Name()}, &buf)
+	require.Nil(t, err)
+	assert.Contains(t, buf.String(), "1 records, 1 chains, 0 checkpoints verified")
+
+	// With a key, the records must be signed.
+	public, _, _ := ed25519.GenerateKey(nil)
+	der, _ := x509.MarshalPKIXPublicKey(public)
+	keyfile, _ := ioutil.TempFile("", "")
+	defer os.Remove(keyfile.Name())
+	keyfile.Write(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	keyfile.Close()
+
+	err = runCommand([]string{"audit", "verify", "-key", keyfile.Name(), tmpfile.Name()}, &buf)
+	assert.Equal(t, tmpfile.Name()+": broken link at line 1: chain start is not signed", err.Error())
+}
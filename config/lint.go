@@ -3,7 +3,9 @@ package config
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"regexp/syntax"
 	"strings"
+	"time"
 
 	"github.com/mozilla/doorman/doorman"
 )
@@ -39,7 +41,87 @@ func lintConfigs(configs ...doorman.ServiceConfig) error {
 					log.Warningf("Avoid coupling of resources with API URIs (%q in %q)", policy.ID, config.Source)
 				}
 			}
+			// Ambiguous patterns with hierarchical resources.
+			if config.ResourceSeparator != "" {
+				for _, resource := range policy.Resources {
+					if ambiguous(resource, config.ResourceSeparator) {
+						log.Warningf("Ambiguous resource %q with separator %q (%q in %q)", resource, config.ResourceSeparator, policy.ID, config.Source)
+					}
+				}
+			}
+			// Shadow policies.
+			if policy.Mode == doorman.ShadowMode {
+				log.Infof("Policy is in shadow mode (%q in %q)", policy.ID, config.Source)
+			}
+			// Expired policies.
+			if expired(policy) {
+				log.Warningf("Policy has expired (%q in %q)", policy.ID, config.Source)
+			}
 		}
 	}
 	return nil
 }
+
+// expired returns true if the policy validity or one of its date conditions is over.
+func expired(policy doorman.Policy) bool {
+	dates := []string{policy.NotAfter}
+	for _, condition := range policy.Conditions {
+		if condition.Type == "DateBeforeCondition" {
+			if date, ok := condition.Options["date"].(string); ok {
+				dates = append(dates, date)
+			}
+		}
+	}
+	for _, date := range dates {
+		t, err := time.Parse(time.RFC3339, date)
+		if err == nil && t.Before(time.Now()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ambiguous returns true if the resource has empty levels, or if one of its regular
+// expressions can match the separator (ie. several levels of the hierarchy).
+func ambiguous(resource string, separator string) bool {
+	if strings.HasPrefix(resource, separator) || strings.HasSuffix(resource, separator) ||
+		strings.Contains(resource, separator+separator) {
+		return true
+	}
+	for _, part := range strings.Split(resource, "<")[1:] {
+		expr := strings.SplitN(part, ">", 2)[0]
+		re, err := syntax.Parse(expr, syntax.Perl)
+		if err != nil {
+			continue
+		}
+		if matchesSeparator(re, separator) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSeparator returns true if a character of the separator can be matched
+// by a wildcard or a character class of the regular expression.
+func matchesSeparator(re *syntax.Regexp, separator string) bool {
+	switch re.Op {
+	case syntax.OpAnyChar:
+		return true
+	case syntax.OpAnyCharNotNL:
+		return strings.Trim(separator, "\n") != ""
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for _, r := range separator {
+				if re.Rune[i] <= r && r <= re.Rune[i+1] {
+					return true
+				}
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if matchesSeparator(sub, separator) {
+			return true
+		}
+	}
+	return false
+}
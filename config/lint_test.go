@@ -61,4 +61,74 @@ func TestLintingWarnings(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Contains(t, buf.String(), "Avoid coupling of resources with API URIs")
 	buf.Reset()
+
+	// Expired policies
+	c = doorman.ServiceConfig{
+		Service: "abc",
+		Policies: doorman.Policies{
+			doorman.Policy{
+				ID:       "contractors",
+				NotAfter: "2018-01-31T00:00:00Z",
+			},
+			doorman.Policy{
+				ID: "deadline",
+				Conditions: doorman.Conditions{
+					"deadline": doorman.Condition{
+						Type: "DateBeforeCondition",
+						Options: map[string]interface{}{
+							"date": "2018-01-31T00:00:00Z",
+						},
+					},
+				},
+			},
+			doorman.Policy{
+				ID:       "forever",
+				NotAfter: "2999-01-31T00:00:00Z",
+			},
+		},
+	}
+	err = lintConfigs(c)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "Policy has expired")
+	assert.Contains(t, buf.String(), "contractors")
+	assert.Contains(t, buf.String(), "deadline")
+	assert.NotContains(t, buf.String(), "forever")
+	buf.Reset()
+
+	// Ambiguous hierarchical resources
+	c = doorman.ServiceConfig{
+		Service:           "abc",
+		ResourceSeparator: "/",
+		Policies: doorman.Policies{
+			doorman.Policy{
+				ID:        "any-record",
+				Resources: []string{"bucket:main/<.*>"},
+			},
+			doorman.Policy{
+				ID:        "trailing",
+				Resources: []string{"bucket:main/"},
+			},
+			doorman.Policy{
+				ID:        "class-with-separator",
+				Resources: []string{"bucket:<[a-z/]+>"},
+			},
+			doorman.Policy{
+				ID:        "negated-class",
+				Resources: []string{"bucket:main/collection:<[^:]+>"},
+			},
+			doorman.Policy{
+				ID:        "single-level",
+				Resources: []string{"bucket:main/collection:<[^/]+>", "bucket:<[a-z]+>"},
+			},
+		},
+	}
+	err = lintConfigs(c)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "Ambiguous resource")
+	assert.Contains(t, buf.String(), "any-record")
+	assert.Contains(t, buf.String(), "trailing")
+	assert.Contains(t, buf.String(), "class-with-separator")
+	assert.Contains(t, buf.String(), "negated-class")
+	assert.NotContains(t, buf.String(), "single-level")
+	buf.Reset()
 }
@@ -162,3 +162,63 @@ policies:
 	assert.Equal(t, len(configs[0].Tags["admins"]), 2)
 	assert.Equal(t, len(configs[0].Tags["editors"]), 1)
 }
+
+func TestLoadRoleBindings(t *testing.T) {
+	configs, err := loadTempFiles(`
+identityProvider:
+service: a
+roleBindings:
+  -
+    principals:
+      - tag:admins
+    roles:
+      - editor
+    resources:
+      - article/<.*>
+clientRoles:
+  disabled: true
+  allowed:
+    - author
+policies:
+  -
+    id: "1"
+    effect: allow
+`)
+	assert.Nil(t, err)
+	require.Equal(t, len(configs), 1)
+	require.Equal(t, len(configs[0].RoleBindings), 1)
+	assert.Equal(t, doorman.Principals{"tag:admins"}, configs[0].RoleBindings[0].Principals)
+	assert.Equal(t, []string{"editor"}, configs[0].RoleBindings[0].Roles)
+	assert.Equal(t, []string{"article/<.*>"}, configs[0].RoleBindings[0].Resources)
+	assert.True(t, configs[0].ClientRoles.Disabled)
+	assert.Equal(t, []string{"author"}, configs[0].ClientRoles.Allowed)
+}
+
+func TestLoadCompositeConditions(t *testing.T) {
+	configs, err := loadTempFiles(`
+identityProvider:
+service: a
+policies:
+  -
+    id: "1"
+    conditions:
+      stageOrVPN:
+        type: AnyOfCondition
+        options:
+          conditions:
+            env:
+              type: StringEqualCondition
+              options:
+                equals: stage
+            remoteIP:
+              type: CIDRCondition
+              options:
+                cidr: 10.0.0.0/8
+    effect: allow
+`)
+	require.Nil(t, err)
+
+	d := doorman.NewDefaultLadon()
+	err = d.LoadPolicies(configs)
+	assert.Nil(t, err)
+}
@@ -27,10 +27,23 @@ type Policy struct {
 	ID          string
 	Description string
 	Principals  []string
-	Effect      string
-	Resources   []string
-	Actions     []string
-	Conditions  Conditions
+	// ExceptPrincipals exclude the policy if one of them is among the principals.
+	ExceptPrincipals []string `yaml:"exceptPrincipals"`
+	Effect           string
+	Resources        []string
+	Actions          []string
+	Conditions       Conditions
+	// NotBefore and NotAfter are RFC 3339 timestamps that limit the validity of
+	// the policy (optional).
+	NotBefore string `yaml:"notBefore"`
+	NotAfter  string `yaml:"notAfter"`
+	// Mode is either enforce (default) or shadow: shadow policies are evaluated
+	// but never change the decisions, and divergences are logged.
+	Mode string
+	// Reason, Obligations and Advice are returned in the decisions of the policy.
+	Reason      string
+	Obligations map[string]interface{}
+	Advice      map[string]interface{}
 }
 
 // Policies is a collection of policies.
@@ -41,24 +54,33 @@ type ServiceConfig struct {
 	Source           string
 	Service          string
 	IdentityProvider string `yaml:"identityProvider"`
-	Tags             Tags
-	Policies         Policies
+	// CombiningAlgorithm is one of deny-overrides (default), permit-overrides or first-applicable.
+	CombiningAlgorithm string `yaml:"combiningAlgorithm"`
+	// ResourceSeparator enables hierarchical resources (eg. "/"): policies on a
+	// resource apply to its descendants.
+	ResourceSeparator string `yaml:"resourceSeparator"`
+	Tags              Tags
+	// RoleBindings grant roles to principals, in addition to the ones provided by clients.
+	RoleBindings RoleBindings `yaml:"roleBindings"`
+	// ClientRoles restricts the roles provided by clients.
+	ClientRoles ClientRoles `yaml:"clientRoles"`
+	// AttributeProviders enrich the context of requests with attributes of the resources.
+	AttributeProviders []AttributeProvider `yaml:"attributeProviders"`
+	// Relations are the userset rewrites of the relationship tuples, by object type.
+	Relations Relations
+	Policies  Policies
 }
 
 // GetTags returns the tags principals for the ones specified.
+//
+// Deprecated: the tags are expanded by Doorman.ExpandPrincipals, with nested tags
+// and regular expressions. GetTags returns no tag if they are invalid.
 func (c *ServiceConfig) GetTags(principals Principals) Principals {
-	result := Principals{}
-	for tag, members := range c.Tags {
-		for _, member := range members {
-			for _, principal := range principals {
-				if principal == member {
-					prefixed := fmt.Sprintf("tag:%s", tag)
-					result = append(result, prefixed)
-				}
-			}
-		}
+	idx, err := newTagIndex(c.Tags)
+	if err != nil {
+		return Principals{}
 	}
-	return result
+	return idx.expand(principals)
 }
 
 // ServicesConfig is the whole set of policies files.
@@ -80,6 +102,19 @@ type Request struct {
 	Action string
 	// Context is the request's environmental context.
 	Context Context
+	// Metadata describes where the request comes from. It is only recorded
+	// by the audit logger, and cannot be posted by clients.
+	Metadata Metadata `json:"-"`
+}
+
+// Metadata is the information about a request that is not matched against policies.
+type Metadata struct {
+	// RemoteAddr is the network address of the client.
+	RemoteAddr string
+	// RequestID identifies the HTTP request.
+	RequestID string
+	// Subject is the user ID obtained from the authentication token.
+	Subject string
 }
 
 // Roles reads the roles from request context and returns the principals.
@@ -98,6 +133,37 @@ func (r *Request) Roles() Principals {
 	return p
 }
 
+const (
+	// ReasonAllowed is used when the request was allowed by a policy.
+	ReasonAllowed = "allowed"
+	// ReasonExplicitDeny is used when the request was denied by a deny policy.
+	ReasonExplicitDeny = "explicit-deny"
+	// ReasonNoMatch is used when the request was denied because no policy matched.
+	ReasonNoMatch = "no-match"
+	// ReasonMissingAttributes is used when the attributes of the resource could not be obtained.
+	ReasonMissingAttributes = "missing-attributes"
+	// ReasonUnknownService is used when the request was denied because the service is unknown.
+	ReasonUnknownService = "unknown-service"
+)
+
+// Decision is the answer to an authorization request.
+type Decision struct {
+	// Allowed is true if the request is allowed.
+	Allowed bool `json:"allowed"`
+	// Policies are the IDs of the policies that decided.
+	Policies []string `json:"policies"`
+	// Principal is the principal that matched the deciding policies.
+	Principal string `json:"principal,omitempty"`
+	// Reason tells why the request was allowed or denied.
+	Reason string `json:"reason"`
+	// Messages are the reasons given by the deciding policies.
+	Messages []string `json:"messages,omitempty"`
+	// Obligations must be fulfilled by the service when applying the decision.
+	Obligations map[string]interface{} `json:"obligations,omitempty"`
+	// Advice may be taken into account by the service.
+	Advice map[string]interface{} `json:"advice,omitempty"`
+}
+
 // Doorman is the backend in charge of checking requests against policies.
 type Doorman interface {
 	// LoadPolicies is responsible for loading the services configuration into memory.
@@ -109,5 +175,15 @@ type Doorman interface {
 	// ExpandPrincipals looks up and add extra principals to the ones specified.
 	ExpandPrincipals(service string, principals Principals) Principals
 	// IsAllowed is responsible for deciding if the specified authorization is allowed for the specified service.
-	IsAllowed(service string, request *Request) bool
+	IsAllowed(service string, request *Request) *Decision
+	// Permissions returns the actions granted to the request principals on the request resource (optional).
+	Permissions(service string, request *Request) []Permission
+	// AllowedPrincipals returns the principals allowed to perform the request action on the request resource.
+	AllowedPrincipals(service string, request *Request) Principals
+	// Roles returns the roles of the request principals.
+	Roles(service string, request *Request) Principals
+	// UpdateTuples removes and adds relationship tuples of the service, all at once.
+	UpdateTuples(service string, deletes []Tuple, writes []Tuple) error
+	// ShadowStats returns the divergences of shadow policies, by service.
+	ShadowStats() map[string]ShadowStats
 }
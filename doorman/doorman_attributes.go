@@ -0,0 +1,263 @@
+package doorman
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ory/ladon"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultAttributesTimeout is the default timeout of HTTP attribute providers.
+	DefaultAttributesTimeout = 1 * time.Second
+	// DefaultAttributesCacheTTL is the default cache duration of HTTP attribute providers.
+	DefaultAttributesCacheTTL = 1 * time.Minute
+	// DefaultAttributesCacheSize is the maximum number of responses cached by HTTP
+	// attribute providers.
+	DefaultAttributesCacheSize = 10000
+)
+
+// AttributeProvider is a Policy Information Point that provides attributes of the
+// resources (eg. owner, project), to enrich the context of authorization requests.
+type AttributeProvider struct {
+	// URL is queried with GET. The "{resource}" and "{action}" placeholders are
+	// replaced with the request values. The response is a JSON object.
+	URL string
+	// File is a local JSON file that maps resources to their attributes. It is read
+	// when the policies are loaded.
+	File string
+	// Attributes are the context fields set from the provider. The request is denied
+	// if one of them is missing.
+	Attributes []string
+	// Timeout of HTTP requests (eg. "500ms").
+	Timeout string
+	// CacheTTL is the cache duration of HTTP responses ("0s" to disable).
+	CacheTTL string `yaml:"cacheTTL"`
+}
+
+// attributeSource returns the attributes for a request.
+type attributeSource interface {
+	lookup(request *Request) (map[string]interface{}, error)
+}
+
+type attributeProvider struct {
+	source     attributeSource
+	attributes []string
+}
+
+// attributeProviders enrich the context of the requests of a service.
+type attributeProviders []*attributeProvider
+
+func newAttributeProviders(configs []AttributeProvider) (attributeProviders, error) {
+	providers := attributeProviders{}
+	for i, config := range configs {
+		where := fmt.Sprintf("attribute provider #%d", i+1)
+		if len(config.Attributes) == 0 {
+			return nil, fmt.Errorf("missing attributes in %s", where)
+		}
+
+		var source attributeSource
+		var err error
+		switch {
+		case config.URL != "" && config.File == "":
+			source, err = newHTTPAttributes(config)
+		case config.File != "" && config.URL == "":
+			source, err = newFileAttributes(config.File)
+		default:
+			err = fmt.Errorf("either URL or file must be specified")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s in %s", err, where)
+		}
+		providers = append(providers, &attributeProvider{
+			source:     source,
+			attributes: config.Attributes,
+		})
+	}
+	return providers, nil
+}
+
+// enrich sets the provided attributes in the context, overriding the values
+// sent by the client. It returns an error if one of them is missing.
+func (providers attributeProviders) enrich(request *Request, context ladon.Context) error {
+	for _, provider := range providers {
+		values, err := provider.source.lookup(request)
+		if err != nil {
+			return err
+		}
+		for _, attribute := range provider.attributes {
+			value, ok := values[attribute]
+			if !ok {
+				return fmt.Errorf("missing attribute %q for %q", attribute, request.Resource)
+			}
+			context[attribute] = value
+		}
+	}
+	return nil
+}
+
+// fileAttributes are the attributes of resources read from a JSON file.
+type fileAttributes map[string]map[string]interface{}
+
+func newFileAttributes(filename string) (fileAttributes, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var attributes fileAttributes
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, fmt.Errorf("invalid JSON file %q: %s", filename, err)
+	}
+	return attributes, nil
+}
+
+func (f fileAttributes) lookup(request *Request) (map[string]interface{}, error) {
+	values, ok := f[request.Resource]
+	if !ok {
+		return nil, fmt.Errorf("no attributes for %q", request.Resource)
+	}
+	return values, nil
+}
+
+// httpAttributes are the attributes of resources fetched from an HTTP endpoint.
+type httpAttributes struct {
+	url    string
+	client *http.Client
+	cache  *attributesCache
+}
+
+func newHTTPAttributes(config AttributeProvider) (*httpAttributes, error) {
+	if _, err := url.Parse(config.URL); err != nil {
+		return nil, err
+	}
+	timeout, err := parseDuration(config.Timeout, DefaultAttributesTimeout)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := parseDuration(config.CacheTTL, DefaultAttributesCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &httpAttributes{
+		url:    config.URL,
+		client: &http.Client{Timeout: timeout},
+		cache:  newAttributesCache(ttl, DefaultAttributesCacheSize),
+	}, nil
+}
+
+// parseDuration parses the value, or returns the default if empty.
+func parseDuration(value string, d time.Duration) (time.Duration, error) {
+	if value == "" {
+		return d, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return parsed, nil
+}
+
+func (h *httpAttributes) lookup(request *Request) (map[string]interface{}, error) {
+	uri := strings.NewReplacer(
+		"{resource}", url.PathEscape(request.Resource),
+		"{action}", url.PathEscape(request.Action),
+	).Replace(h.url)
+
+	if values, ok := h.cache.get(uri); ok {
+		return values, nil
+	}
+
+	log.Debugf("Fetch attributes from %s", uri)
+	req, _ := http.NewRequest("GET", uri, nil)
+	req.Header.Add("Accept", "application/json")
+	response, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch attributes: %s", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch attributes from %s (%s)", uri, response.Status)
+	}
+	var values map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&values); err != nil {
+		return nil, fmt.Errorf("could not read attributes from %s: %s", uri, err)
+	}
+
+	h.cache.set(uri, values)
+	return values, nil
+}
+
+// attributesCache keeps the fetched attributes for a fixed duration. When full,
+// the least recently used entries are evicted.
+type attributesCache struct {
+	ttl     time.Duration
+	size    int
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order lists the entries from the most to the least recently used.
+	order *list.List
+}
+
+type attributesEntry struct {
+	key     string
+	values  map[string]interface{}
+	expires time.Time
+}
+
+func newAttributesCache(ttl time.Duration, size int) *attributesCache {
+	return &attributesCache{
+		ttl:     ttl,
+		size:    size,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+func (c *attributesCache) get(key string) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*attributesEntry)
+	if !now().Before(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.values, true
+}
+
+func (c *attributesCache) set(key string, values map[string]interface{}) {
+	if c.ttl <= 0 || c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*attributesEntry)
+		entry.values = values
+		entry.expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&attributesEntry{key: key, values: values, expires: expires})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *attributesCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*attributesEntry).key)
+}
@@ -0,0 +1,277 @@
+package doorman
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// attributesServer is a stand-in for a Policy Information Point.
+func attributesServer(hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		switch r.URL.Path {
+		case "/records/42":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"owner": "userid:alice", "project": "doorman"}`)
+		case "/records/43":
+			fmt.Fprint(w, `{"project": "doorman"}`)
+		case "/records/slow":
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprint(w, `{"owner": "userid:alice"}`)
+		case "/records/bad":
+			fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func attributesDoorman(t *testing.T, providers ...AttributeProvider) *LadonDoorman {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service:            "a",
+			AttributeProviders: providers,
+			Policies: Policies{
+				Policy{
+					ID:         "owner",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"<.*>"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"owner": Condition{
+							Type: "MatchPrincipalsCondition",
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+	return d
+}
+
+func TestHTTPAttributeProvider(t *testing.T) {
+	var hits int32
+	server := attributesServer(&hits)
+	defer server.Close()
+
+	d := attributesDoorman(t, AttributeProvider{
+		URL:        server.URL + "/records/{resource}",
+		Attributes: []string{"owner"},
+		Timeout:    "50ms",
+	})
+
+	// Owner is obtained from provider.
+	decision := d.IsAllowed("a", &Request{
+		Principals: Principals{"userid:alice"},
+		Action:     "delete",
+		Resource:   "42",
+	})
+	assert.True(t, decision.Allowed)
+
+	// Provider overrides the context sent by the client.
+	decision = d.IsAllowed("a", &Request{
+		Principals: Principals{"userid:mallory"},
+		Action:     "delete",
+		Resource:   "42",
+		Context: Context{
+			"owner": "userid:mallory",
+		},
+	})
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, ReasonNoMatch, decision.Reason)
+
+	// Responses are cached.
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+	// Missing attributes, unknown resources, timeouts or bad responses deny.
+	for _, resource := range []string{"43", "44", "slow", "bad"} {
+		decision = d.IsAllowed("a", &Request{
+			Principals: Principals{"userid:alice"},
+			Action:     "delete",
+			Resource:   resource,
+			Context: Context{
+				"owner": "userid:alice",
+			},
+		})
+		assert.False(t, decision.Allowed, resource)
+		assert.Equal(t, ReasonMissingAttributes, decision.Reason, resource)
+	}
+}
+
+func TestAttributesCacheTTL(t *testing.T) {
+	var hits int32
+	server := attributesServer(&hits)
+	defer server.Close()
+
+	d := attributesDoorman(t, AttributeProvider{
+		URL:        server.URL + "/records/{resource}",
+		Attributes: []string{"owner", "project"},
+		CacheTTL:   "10s",
+	})
+	request := &Request{
+		Principals: Principals{"userid:alice"},
+		Action:     "delete",
+		Resource:   "42",
+	}
+
+	defer setNow(time.Date(2018, 1, 31, 12, 0, 0, 0, time.UTC))()
+	d.IsAllowed("a", request)
+	setNow(time.Date(2018, 1, 31, 12, 0, 9, 0, time.UTC))
+	d.IsAllowed("a", request)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+	setNow(time.Date(2018, 1, 31, 12, 0, 10, 0, time.UTC))
+	d.IsAllowed("a", request)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	// Cache disabled.
+	d = attributesDoorman(t, AttributeProvider{
+		URL:        server.URL + "/records/{resource}",
+		Attributes: []string{"owner"},
+		CacheTTL:   "0s",
+	})
+	d.IsAllowed("a", request)
+	d.IsAllowed("a", request)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&hits))
+}
+
+func TestAttributesCacheSize(t *testing.T) {
+	cache := newAttributesCache(10*time.Second, 2)
+	cache.set("a", map[string]interface{}{"owner": "a"})
+	cache.set("b", map[string]interface{}{"owner": "b"})
+
+	// Reading an entry makes it the most recently used.
+	_, ok := cache.get("a")
+	assert.True(t, ok)
+
+	cache.set("c", map[string]interface{}{"owner": "c"})
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	_, ok = cache.get("a")
+	assert.True(t, ok)
+	_, ok = cache.get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 2, cache.order.Len())
+}
+
+func TestFileAttributeProvider(t *testing.T) {
+	tmpfile, _ := ioutil.TempFile("", "")
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Write([]byte(`{"42": {"owner": "userid:alice"}, "43": {}}`))
+	tmpfile.Close()
+
+	d := attributesDoorman(t, AttributeProvider{
+		File:       tmpfile.Name(),
+		Attributes: []string{"owner"},
+	})
+
+	for _, test := range []struct {
+		resource string
+		reason   string
+	}{
+		{"42", ReasonAllowed},
+		{"43", ReasonMissingAttributes},
+		{"44", ReasonMissingAttributes},
+	} {
+		decision := d.IsAllowed("a", &Request{
+			Principals: Principals{"userid:alice"},
+			Action:     "delete",
+			Resource:   test.resource,
+		})
+		assert.Equal(t, test.reason, decision.Reason, test.resource)
+	}
+}
+
+func TestBadAttributeProviders(t *testing.T) {
+	for _, test := range []struct {
+		provider AttributeProvider
+		expected string
+	}{
+		{AttributeProvider{URL: "http://pip"}, "missing attributes in attribute provider #1"},
+		{AttributeProvider{Attributes: []string{"owner"}}, "either URL or file must be specified"},
+		{AttributeProvider{URL: "http://pip", File: "a.json", Attributes: []string{"owner"}}, "either URL or file must be specified"},
+		{AttributeProvider{URL: "http://pip", Attributes: []string{"owner"}, Timeout: "1 second"}, "invalid duration \"1 second\""},
+		{AttributeProvider{URL: "http://pip", Attributes: []string{"owner"}, CacheTTL: "forever"}, "invalid duration \"forever\""},
+		{AttributeProvider{File: "/tmp/unknown.json", Attributes: []string{"owner"}}, "no such file"},
+	} {
+		d := NewDefaultLadon()
+		err := d.LoadPolicies(ServicesConfig{
+			ServiceConfig{
+				Service:            "a",
+				AttributeProviders: []AttributeProvider{test.provider},
+			},
+		})
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), test.expected)
+	}
+}
+
+func TestAttributesListings(t *testing.T) {
+	tmpfile, _ := ioutil.TempFile("", "")
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Write([]byte(`{"42": {"project": "doorman"}, "43": {"project": "other"}}`))
+	tmpfile.Close()
+
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			AttributeProviders: []AttributeProvider{
+				{File: tmpfile.Name(), Attributes: []string{"project"}},
+			},
+			Policies: Policies{
+				Policy{
+					ID:         "project",
+					Principals: Principals{"userid:alice"},
+					Actions:    []string{"read"},
+					Resources:  []string{"42", "43"},
+					Conditions: Conditions{
+						"project": Condition{
+							Type:    "StringEqualCondition",
+							Options: map[string]interface{}{"equals": "doorman"},
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	// The attributes of the resources override the ones sent by the client.
+	permissions := d.Permissions("a", &Request{
+		Principals: Principals{"userid:alice"},
+		Context:    Context{"project": "doorman"},
+	})
+	assert.Equal(t, []Permission{
+		{Action: "read", Resource: "42"},
+	}, permissions)
+
+	for _, test := range []struct {
+		resource string
+		expected Principals
+	}{
+		{"42", Principals{"userid:alice"}},
+		{"43", Principals{}},
+		{"44", Principals{}},
+	} {
+		principals := d.AllowedPrincipals("a", &Request{
+			Action:   "read",
+			Resource: test.resource,
+			Context:  Context{"project": "doorman"},
+		})
+		assert.Equal(t, test.expected, principals, test.resource)
+	}
+}
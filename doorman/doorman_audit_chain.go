@@ -0,0 +1,363 @@
+package doorman
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditCheckpoint is the type of the signed entries of the audit chain.
+const AuditCheckpoint = "request.authorization.checkpoint"
+
+// DefaultAuditCheckpointInterval is the delay between two checkpoints of the audit chain.
+const DefaultAuditCheckpointInterval = time.Minute
+
+// AuditChain makes the audit log tamper-evident: each entry is numbered (seq) and
+// carries the hash of the previous one (prevHash). Checkpoints are periodically
+// appended to the chain, with an Ed25519 signature of the last hash.
+//
+// A new chain starts (at seq 1) every time the server starts, with a checkpoint.
+type AuditChain struct {
+	key      ed25519.PrivateKey
+	interval time.Duration
+
+	mu             sync.Mutex
+	seq            int64
+	prevHash       string
+	lastCheckpoint time.Time
+	// pending is the number of entries since the last checkpoint.
+	pending int
+}
+
+// NewAuditChain instantiates a chain whose checkpoints are signed with the specified key.
+// A checkpoint is added every interval if entries were written since the last one.
+func NewAuditChain(key ed25519.PrivateKey, interval time.Duration) *AuditChain {
+	return &AuditChain{
+		key:      key,
+		interval: interval,
+	}
+}
+
+// SetAuditChain enables the chaining of audit entries (disabled if nil).
+func (doorman *LadonDoorman) SetAuditChain(chain *AuditChain) {
+	doorman.auditLogger().setChain(chain)
+}
+
+// link numbers the entry and returns the entries to write: the signed start of the
+// chain if it is the first one, the entry, and a checkpoint if it is due.
+// The entries already chained are returned along with the error, if any.
+// The lock must be held until the entries are written.
+func (c *AuditChain) link(entry *AuditEntry) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	if c.seq == 0 {
+		// Sign the start of the chain, so that chains cannot be forged.
+		checkpoint, err := c.checkpoint(entry.Time)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, checkpoint)
+	}
+
+	if err := c.append(entry); err != nil {
+		return entries, err
+	}
+	c.pending++
+	entries = append(entries, entry)
+
+	if entry.Time.Sub(c.lastCheckpoint) >= c.interval {
+		checkpoint, err := c.checkpoint(entry.Time)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, checkpoint)
+	}
+	return entries, nil
+}
+
+// flush returns a checkpoint if entries were chained since the last one.
+// The lock must be held until the entries are written.
+func (c *AuditChain) flush(at time.Time) ([]*AuditEntry, error) {
+	if c.pending == 0 {
+		return nil, nil
+	}
+	checkpoint, err := c.checkpoint(at)
+	if err != nil {
+		return nil, err
+	}
+	return []*AuditEntry{checkpoint}, nil
+}
+
+// checkpoint appends an entry with the signature of the last hash.
+func (c *AuditChain) checkpoint(at time.Time) (*AuditEntry, error) {
+	checkpoint := &AuditEntry{
+		Time: at,
+		Type: AuditCheckpoint,
+		Fields: map[string]interface{}{
+			"signature": base64.StdEncoding.EncodeToString(
+				ed25519.Sign(c.key, checkpointMessage(c.seq+1, c.prevHash)),
+			),
+		},
+	}
+	if err := c.append(checkpoint); err != nil {
+		return nil, err
+	}
+	c.lastCheckpoint = at
+	c.pending = 0
+	return checkpoint, nil
+}
+
+// append sets the chain fields of the entry, and keeps its hash for the next one.
+func (c *AuditChain) append(entry *AuditEntry) error {
+	fields := map[string]interface{}{}
+	for k, v := range entry.Fields {
+		fields[k] = v
+	}
+	fields["seq"] = c.seq + 1
+	fields["prevHash"] = c.prevHash
+	entry.Fields = fields
+
+	// Hash the entry as written by the sinks, like the verification does.
+	line, err := mozLog(entry)
+	if err != nil {
+		return err
+	}
+	hash, err := recordHash(line)
+	if err != nil {
+		return err
+	}
+	c.seq++
+	c.prevHash = hash
+	return nil
+}
+
+// checkpointMessage is the signed content of checkpoints: the number of the checkpoint
+// and the hash of the previous entry.
+func checkpointMessage(seq int64, prevHash string) []byte {
+	return []byte(fmt.Sprintf("%d %s", seq, prevHash))
+}
+
+// recordHash returns the SHA-256 of the canonical JSON (ie. with sorted keys and numbers
+// written as is) of the Timestamp, Type and Fields of the MozLog line. The other
+// attributes (eg. hostname) are not part of the chain.
+func recordHash(line []byte) (string, error) {
+	var record struct {
+		Timestamp json.Number
+		Type      string
+		Fields    map[string]interface{}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return "", err
+	}
+	canonical, err := json.Marshal(map[string]interface{}{
+		"Timestamp": record.Timestamp,
+		"Type":      record.Type,
+		"Fields":    record.Fields,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditVerification is the result of the verification of an audit log.
+type AuditVerification struct {
+	// Records is the number of chained entries.
+	Records int
+	// Checkpoints is the number of checkpoints whose signature is valid.
+	Checkpoints int
+	// Chains is the number of chains (ie. server starts) found in the log.
+	Chains int
+}
+
+// AuditVerifier checks the links of the chain in audit logs. Consecutive logs
+// (eg. rotated files, oldest first) can be verified as a single one.
+//
+// Without key, the checkpoints signatures are not checked, and the logs may start
+// in the middle of a chain. With a key, each chain must start with a valid checkpoint,
+// and every record must be covered by the valid checkpoint that follows it.
+type AuditVerifier struct {
+	key    ed25519.PublicKey
+	result AuditVerification
+
+	seq      int64
+	prevHash string
+	// uncovered is the number of records since the last valid checkpoint.
+	uncovered int
+}
+
+// NewAuditVerifier instantiates a verifier. The key is optional.
+func NewAuditVerifier(key ed25519.PublicKey) *AuditVerifier {
+	return &AuditVerifier{key: key}
+}
+
+// Verify reads the MozLog entries of the log and checks the links of the chain.
+// The first broken link (or invalid checkpoint signature) is returned as an error,
+// with its line number in this log.
+//
+// The entries without chain fields (eg. request summaries) are ignored, except the
+// authorization entries once a chain started (or always with a key).
+func (v *AuditVerifier) Verify(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var record struct {
+			Timestamp json.Number
+			Type      string
+			Fields    map[string]interface{}
+		}
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+		if err := decoder.Decode(&record); err != nil {
+			continue
+		}
+		seqField, ok := record.Fields["seq"].(json.Number)
+		if !ok {
+			// Authorization entries are all chained, they cannot be inserted in the log.
+			if strings.HasPrefix(record.Type, AuditAuthorization) && (v.key != nil || v.result.Records > 0) {
+				return fmt.Errorf("broken link at line %d: %s entry is not chained", line, record.Type)
+			}
+			continue
+		}
+		current, err := seqField.Int64()
+		if err != nil {
+			return fmt.Errorf("broken link at line %d: invalid seq %q", line, seqField)
+		}
+		previous, _ := record.Fields["prevHash"].(string)
+		checkpoint := record.Type == AuditCheckpoint
+
+		switch {
+		case current == 1 && previous == "":
+			// Start of a chain.
+			if v.key != nil && v.uncovered > 0 {
+				return fmt.Errorf("broken link at line %d: %d records of the previous chain are not signed", line, v.uncovered)
+			}
+			if v.key != nil && !checkpoint {
+				return fmt.Errorf("broken link at line %d: chain start is not signed", line)
+			}
+			v.result.Chains++
+		case v.result.Records == 0:
+			// Log starts in the middle of a chain.
+			if v.key != nil {
+				return fmt.Errorf("broken link at line %d: seq %d is not the start of a chain", line, current)
+			}
+			v.result.Chains++
+		case current != v.seq+1:
+			return fmt.Errorf("broken link at line %d: seq %d follows %d", line, current, v.seq)
+		case previous != v.prevHash:
+			return fmt.Errorf("broken link at line %d: seq %d does not match previous hash", line, current)
+		}
+
+		if checkpoint && v.key != nil {
+			signature, _ := record.Fields["signature"].(string)
+			decoded, err := base64.StdEncoding.DecodeString(signature)
+			if err != nil || !ed25519.Verify(v.key, checkpointMessage(current, previous), decoded) {
+				return fmt.Errorf("broken link at line %d: invalid checkpoint signature", line)
+			}
+			v.result.Checkpoints++
+			v.uncovered = 0
+		} else {
+			v.uncovered++
+		}
+
+		hash, err := recordHash(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("broken link at line %d: %s", line, err)
+		}
+		v.seq = current
+		v.prevHash = hash
+		v.result.Records++
+	}
+	return scanner.Err()
+}
+
+// Result returns the counts of the logs verified so far. With a key, an error is
+// returned if the last records are not covered by a checkpoint (eg. the log was
+// truncated, or the server stopped before the next checkpoint).
+func (v *AuditVerifier) Result() (*AuditVerification, error) {
+	result := v.result
+	if v.key != nil && v.uncovered > 0 {
+		return &result, fmt.Errorf("the last %d records are not signed", v.uncovered)
+	}
+	return &result, nil
+}
+
+// VerifyAuditLog checks the chain of a single audit log (see AuditVerifier).
+func VerifyAuditLog(r io.Reader, key ed25519.PublicKey) (*AuditVerification, error) {
+	v := NewAuditVerifier(key)
+	if err := v.Verify(r); err != nil {
+		result := v.result
+		return &result, err
+	}
+	return v.Result()
+}
+
+// LoadAuditSigningKey reads the Ed25519 private key from a PEM file (PKCS #8).
+func LoadAuditSigningKey(filename string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(filename)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %q: %s", filename, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid key %q: not an Ed25519 key", filename)
+	}
+	return key, nil
+}
+
+// LoadAuditVerifyKey reads the Ed25519 public key from a PEM file (PKIX). The public
+// key is derived if the file contains the private key.
+func LoadAuditVerifyKey(filename string) (ed25519.PublicKey, error) {
+	block, err := readPEM(filename)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "PRIVATE KEY" {
+		private, err := LoadAuditSigningKey(filename)
+		if err != nil {
+			return nil, err
+		}
+		return private.Public().(ed25519.PublicKey), nil
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %q: %s", filename, err)
+	}
+	key, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid key %q: not an Ed25519 key", filename)
+	}
+	return key, nil
+}
+
+func readPEM(filename string) (*pem.Block, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("invalid key %q: no PEM data", filename)
+	}
+	return block, nil
+}
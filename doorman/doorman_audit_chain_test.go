@@ -0,0 +1,239 @@
+package doorman
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chainedLog returns the lines of the audit log of five requests (30 seconds apart)
+// with a checkpoint at the start of the chain and every minute.
+func chainedLog(t *testing.T, key ed25519.PrivateKey) []string {
+	var buf bytes.Buffer
+	doorman := sampleDoorman()
+	doorman.SetAuditSinks(NewMozLogAuditSink(&buf))
+	doorman.SetAuditChain(NewAuditChain(key, time.Minute))
+
+	start := time.Date(2018, 1, 31, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		restore := setNow(start.Add(time.Duration(i) * 30 * time.Second))
+		doorman.IsAllowed("https://sample.yaml", &Request{
+			Principals: Principals{"userid:foo"},
+			Action:     "update",
+			Resource:   "server.org/blocklist:onecrl",
+			Context:    Context{"amount": 3.14159, "big": 12345678901234567},
+		})
+		restore()
+	}
+	lines := strings.SplitAfter(buf.String(), "\n")
+	return lines[:len(lines)-1]
+}
+
+func verify(lines []string, key ed25519.PublicKey) (*AuditVerification, error) {
+	return VerifyAuditLog(strings.NewReader(strings.Join(lines, "")), key)
+}
+
+func TestAuditChain(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	lines := chainedLog(t, private)
+	require.Len(t, lines, 8)
+	assert.Contains(t, lines[0], AuditCheckpoint)
+	assert.Contains(t, lines[0], "\"seq\":1")
+	assert.Contains(t, lines[0], "\"prevHash\":\"\"")
+	assert.Contains(t, lines[4], AuditCheckpoint)
+	assert.Contains(t, lines[7], AuditCheckpoint)
+
+	result, err := verify(lines, public)
+	require.Nil(t, err)
+	assert.Equal(t, &AuditVerification{Records: 8, Checkpoints: 3, Chains: 1}, result)
+
+	// Without key.
+	result, err = verify(lines, nil)
+	require.Nil(t, err)
+	assert.Equal(t, 0, result.Checkpoints)
+
+	// Other entries are ignored.
+	other := append([]string{"not json\n", "{\"Type\": \"request.summary\", \"Fields\": {}}\n"}, lines...)
+	result, err = verify(other, public)
+	require.Nil(t, err)
+	assert.Equal(t, 8, result.Records)
+
+	// Restarts begin new chains.
+	result, err = verify(append(append([]string{}, lines...), lines...), public)
+	require.Nil(t, err)
+	assert.Equal(t, 2, result.Chains)
+
+	// Consecutive logs are verified as a single one.
+	v := NewAuditVerifier(public)
+	require.Nil(t, v.Verify(strings.NewReader(strings.Join(lines[:3], ""))))
+	require.Nil(t, v.Verify(strings.NewReader(strings.Join(lines[3:], ""))))
+	result, err = v.Result()
+	require.Nil(t, err)
+	assert.Equal(t, 8, result.Records)
+}
+
+func TestAuditChainCoverage(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	lines := chainedLog(t, private)
+
+	// Logs may start in the middle of a chain only without key.
+	result, err := verify(lines[2:], nil)
+	require.Nil(t, err)
+	assert.Equal(t, 6, result.Records)
+	_, err = verify(lines[2:], public)
+	assert.Equal(t, "broken link at line 1: seq 3 is not the start of a chain", err.Error())
+
+	// Last records not followed by a checkpoint.
+	_, err = verify(lines[:6], nil)
+	require.Nil(t, err)
+	_, err = verify(lines[:6], public)
+	assert.Equal(t, "the last 1 records are not signed", err.Error())
+
+	// Chain interrupted before its checkpoint.
+	_, err = verify(append(append([]string{}, lines[:6]...), lines...), public)
+	assert.Equal(t, "broken link at line 7: 1 records of the previous chain are not signed", err.Error())
+
+	// Forged chain.
+	forged := `{"Type": "request.authorization", "Timestamp": 1, "Fields": {"seq": 1, "prevHash": ""}}` + "\n"
+	_, err = verify(append(append([]string{}, lines...), forged), public)
+	assert.Equal(t, "broken link at line 9: chain start is not signed", err.Error())
+}
+
+func TestAuditChainTampering(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	lines := chainedLog(t, private)
+
+	copied := func() []string {
+		return append([]string{}, lines...)
+	}
+
+	// Edited entry.
+	edited := copied()
+	edited[2] = strings.Replace(edited[2], "\"allowed\":true", "\"allowed\":false", 1)
+	_, err := verify(edited, public)
+	assert.Equal(t, "broken link at line 4: seq 4 does not match previous hash", err.Error())
+
+	// Removed entry.
+	removed := copied()
+	removed = append(removed[:3], removed[4:]...)
+	_, err = verify(removed, public)
+	assert.Equal(t, "broken link at line 4: seq 5 follows 3", err.Error())
+
+	// Swapped entries.
+	swapped := copied()
+	swapped[2], swapped[3] = swapped[3], swapped[2]
+	_, err = verify(swapped, public)
+	assert.Equal(t, "broken link at line 3: seq 4 follows 2", err.Error())
+
+	// Inserted entry.
+	inserted := copied()
+	forged := `{"Type": "request.authorization", "Timestamp": 1, "Fields": {"allowed": true}}` + "\n"
+	inserted = append(inserted[:3], append([]string{forged}, inserted[3:]...)...)
+	_, err = verify(inserted, public)
+	assert.Equal(t, "broken link at line 4: request.authorization entry is not chained", err.Error())
+	_, err = verify(inserted, nil)
+	assert.Equal(t, "broken link at line 4: request.authorization entry is not chained", err.Error())
+	_, err = verify(append([]string{forged}, lines...), public)
+	assert.Equal(t, "broken link at line 1: request.authorization entry is not chained", err.Error())
+	result, err := verify(append([]string{forged}, lines...), nil)
+	require.Nil(t, err)
+	assert.Equal(t, 8, result.Records)
+
+	// Other key.
+	other, _, _ := ed25519.GenerateKey(nil)
+	_, err = verify(lines, other)
+	assert.Equal(t, "broken link at line 1: invalid checkpoint signature", err.Error())
+}
+
+type chanAuditSink chan *AuditEntry
+
+func (s chanAuditSink) Write(entry *AuditEntry) error {
+	s <- entry
+	return nil
+}
+
+func (s chanAuditSink) Close() error {
+	return nil
+}
+
+func TestAuditChainTimer(t *testing.T) {
+	_, private, _ := ed25519.GenerateKey(nil)
+	sink := make(chanAuditSink, 10)
+	doorman := sampleDoorman()
+	doorman.SetAuditSinks(sink)
+	doorman.SetAuditChain(NewAuditChain(private, 10*time.Millisecond))
+	defer doorman.SetAuditChain(nil)
+
+	doorman.IsAllowed("https://sample.yaml", &Request{
+		Principals: Principals{"userid:foo"},
+		Action:     "update",
+		Resource:   "server.org/blocklist:onecrl",
+	})
+
+	// The entry is signed without waiting for the next one.
+	types := []string{}
+	for len(types) < 3 {
+		select {
+		case entry := <-sink:
+			types = append(types, entry.Type)
+		case <-time.After(time.Second):
+			t.Fatalf("missing checkpoint after %v", types)
+		}
+	}
+	assert.Equal(t, []string{AuditCheckpoint, AuditAuthorization, AuditCheckpoint}, types)
+
+	// No checkpoint without new entries.
+	select {
+	case entry := <-sink:
+		t.Fatalf("unexpected %s entry", entry.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLoadAuditKeys(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "keys")
+	defer os.RemoveAll(dir)
+
+	public, private, _ := ed25519.GenerateKey(nil)
+	privateDER, _ := x509.MarshalPKCS8PrivateKey(private)
+	publicDER, _ := x509.MarshalPKIXPublicKey(public)
+	write := func(name string, block *pem.Block) string {
+		filename := filepath.Join(dir, name)
+		ioutil.WriteFile(filename, pem.EncodeToMemory(block), 0600)
+		return filename
+	}
+	privateFile := write("audit.pem", &pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	publicFile := write("audit.pub", &pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	key, err := LoadAuditSigningKey(privateFile)
+	require.Nil(t, err)
+	assert.Equal(t, private, key)
+
+	verifyKey, err := LoadAuditVerifyKey(publicFile)
+	require.Nil(t, err)
+	assert.Equal(t, public, verifyKey)
+
+	// Derived from private key.
+	verifyKey, err = LoadAuditVerifyKey(privateFile)
+	require.Nil(t, err)
+	assert.Equal(t, public, verifyKey)
+
+	// Bad files.
+	_, err = LoadAuditSigningKey(publicFile)
+	assert.Contains(t, err.Error(), "invalid key")
+	_, err = LoadAuditSigningKey(filepath.Join(dir, "unknown"))
+	assert.NotNil(t, err)
+	ioutil.WriteFile(filepath.Join(dir, "empty"), []byte("abc"), 0600)
+	_, err = LoadAuditVerifyKey(filepath.Join(dir, "empty"))
+	assert.Equal(t, "invalid key \""+filepath.Join(dir, "empty")+"\": no PEM data", err.Error())
+}
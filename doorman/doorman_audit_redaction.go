@@ -0,0 +1,158 @@
+package doorman
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Redaction actions.
+const (
+	// RedactDrop removes the value.
+	RedactDrop = "drop"
+	// RedactMask replaces the value by asterisks.
+	RedactMask = "mask"
+	// RedactHMAC replaces the value by its HMAC-SHA256, which still allows to
+	// correlate the entries of the same user.
+	RedactHMAC = "hmac"
+)
+
+// redactedMask is the value of masked fields.
+const redactedMask = "***"
+
+// Redactor removes personal data from the log entries, following rules like:
+//
+//	principal:email=hmac    hash the email: principals
+//	principal:userid=mask   mask the userid: principals (and the token subject)
+//	context:phone=drop      remove the phone field of the request context
+type Redactor struct {
+	principals map[string]string
+	context    map[string]string
+	key        []byte
+}
+
+// NewRedactor parses the rules. The key is required by the HMAC rules.
+func NewRedactor(rules []string, key []byte) (*Redactor, error) {
+	r := &Redactor{
+		principals: map[string]string{},
+		context:    map[string]string{},
+		key:        key,
+	}
+	for _, rule := range rules {
+		target := strings.SplitN(rule, "=", 2)
+		if len(target) != 2 {
+			return nil, fmt.Errorf("invalid redaction rule %q", rule)
+		}
+		action := target[1]
+		switch action {
+		case RedactDrop, RedactMask:
+		case RedactHMAC:
+			if len(key) == 0 {
+				return nil, fmt.Errorf("missing HMAC key for redaction rule %q", rule)
+			}
+		default:
+			return nil, fmt.Errorf("invalid redaction rule %q (unknown action %q)", rule, action)
+		}
+		kind := strings.SplitN(target[0], ":", 2)
+		if len(kind) != 2 || kind[1] == "" {
+			return nil, fmt.Errorf("invalid redaction rule %q", rule)
+		}
+		switch kind[0] {
+		case "principal":
+			r.principals[kind[1]] = action
+		case "context":
+			r.context[kind[1]] = action
+		default:
+			return nil, fmt.Errorf("invalid redaction rule %q (unknown target %q)", rule, kind[0])
+		}
+	}
+	return r, nil
+}
+
+// SetAuditRedactor applies the redaction rules to the audit entries (disabled if nil).
+func (doorman *LadonDoorman) SetAuditRedactor(r *Redactor) {
+	doorman.auditLogger().setRedactor(r)
+}
+
+// Principals returns the principals without the dropped ones, and with the others
+// masked or hashed (their prefix is kept, eg. email:***).
+func (r *Redactor) Principals(principals Principals) Principals {
+	if r == nil || len(r.principals) == 0 {
+		return principals
+	}
+	redacted := Principals{}
+	for _, principal := range principals {
+		if p, ok := r.principal(principal); ok {
+			redacted = append(redacted, p)
+		}
+	}
+	return redacted
+}
+
+// Subject returns the user ID of the token, redacted like the userid: principals.
+func (r *Redactor) Subject(subject string) string {
+	if r == nil || subject == "" {
+		return subject
+	}
+	p, ok := r.principal("userid:" + subject)
+	if !ok {
+		return ""
+	}
+	return strings.TrimPrefix(p, "userid:")
+}
+
+func (r *Redactor) principal(principal string) (string, bool) {
+	parts := strings.SplitN(principal, ":", 2)
+	if len(parts) != 2 {
+		return principal, true
+	}
+	action, ok := r.principals[parts[0]]
+	if !ok {
+		return principal, true
+	}
+	value, keep := r.redact(action, parts[1])
+	if !keep {
+		return "", false
+	}
+	return parts[0] + ":" + value, true
+}
+
+// Context returns a copy of the context, with the fields redacted.
+func (r *Redactor) Context(context map[string]interface{}) map[string]interface{} {
+	if r == nil || len(r.context) == 0 {
+		return context
+	}
+	redacted := map[string]interface{}{}
+	for k, v := range context {
+		action, ok := r.context[k]
+		if !ok {
+			redacted[k] = v
+			continue
+		}
+		if value, keep := r.redact(action, v); keep {
+			redacted[k] = value
+		}
+	}
+	return redacted
+}
+
+// redact returns the value transformed by the action, and false if it must be dropped.
+func (r *Redactor) redact(action string, value interface{}) (string, bool) {
+	switch action {
+	case RedactDrop:
+		return "", false
+	case RedactHMAC:
+		s, ok := value.(string)
+		if !ok {
+			b, _ := json.Marshal(value)
+			s = string(b)
+		}
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil)), true
+	}
+	return redactedMask, true
+}
@@ -0,0 +1,112 @@
+package doorman
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hmacHex(key string, value string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestNewRedactor(t *testing.T) {
+	_, err := NewRedactor([]string{"principal:email=hmac", "context:phone=drop"}, []byte("secret"))
+	assert.Nil(t, err)
+
+	var cases = []struct {
+		rule string
+		err  string
+	}{
+		{"principal:email", "invalid redaction rule \"principal:email\""},
+		{"principal=mask", "invalid redaction rule \"principal=mask\""},
+		{"principal:=mask", "invalid redaction rule \"principal:=mask\""},
+		{"principal:email=hide", "invalid redaction rule \"principal:email=hide\" (unknown action \"hide\")"},
+		{"header:email=mask", "invalid redaction rule \"header:email=mask\" (unknown target \"header\")"},
+		{"principal:email=hmac", "missing HMAC key for redaction rule \"principal:email=hmac\""},
+	}
+	for _, c := range cases {
+		_, err := NewRedactor([]string{c.rule}, nil)
+		assert.Equal(t, c.err, err.Error())
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	r, err := NewRedactor([]string{
+		"principal:email=hmac",
+		"principal:userid=mask",
+		"principal:group=drop",
+		"context:phone=drop",
+		"context:name=mask",
+		"context:account=hmac",
+	}, []byte("secret"))
+	require.Nil(t, err)
+
+	assert.Equal(t, Principals{
+		"email:" + hmacHex("secret", "ada@eff.org"),
+		"userid:***",
+		"role:editor",
+		"tag",
+	}, r.Principals(Principals{"email:ada@eff.org", "userid:ada", "group:scientists", "role:editor", "tag"}))
+
+	assert.Equal(t, "***", r.Subject("ada"))
+	assert.Equal(t, "", r.Subject(""))
+
+	assert.Equal(t, map[string]interface{}{
+		"name":    "***",
+		"account": hmacHex("secret", "42"),
+		"env":     "stage",
+	}, r.Context(map[string]interface{}{
+		"phone":   "+33 6",
+		"name":    "Ada",
+		"account": 42,
+		"env":     "stage",
+	}))
+
+	// Nil redactor leaves values untouched.
+	var none *Redactor
+	assert.Equal(t, Principals{"userid:ada"}, none.Principals(Principals{"userid:ada"}))
+	assert.Equal(t, "ada", none.Subject("ada"))
+	assert.Equal(t, map[string]interface{}{"phone": "+33 6"}, none.Context(map[string]interface{}{"phone": "+33 6"}))
+
+	// Dropped subject.
+	r, _ = NewRedactor([]string{"principal:userid=drop"}, nil)
+	assert.Equal(t, "", r.Subject("ada"))
+}
+
+func TestAuditRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	doorman := sampleDoorman()
+	doorman.SetAuditSinks(NewMozLogAuditSink(&buf))
+	r, _ := NewRedactor([]string{"principal:userid=hmac", "context:email=drop"}, []byte("secret"))
+	doorman.SetAuditRedactor(r)
+
+	request := &Request{
+		Principals: Principals{"userid:foo"},
+		Action:     "update",
+		Resource:   "server.org/blocklist:onecrl",
+		Context:    Context{"email": "foo@bar.com", "env": "stage"},
+		Metadata:   Metadata{Subject: "foo"},
+	}
+	decision := doorman.IsAllowed("https://sample.yaml", request)
+	// Decision is taken on actual values.
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, Principals{"userid:foo"}, request.Principals)
+
+	var entry struct {
+		Fields map[string]interface{}
+	}
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, []interface{}{"userid:" + hmacHex("secret", "foo")}, entry.Fields["principals"])
+	assert.Equal(t, hmacHex("secret", "foo"), entry.Fields["subject"])
+	assert.Equal(t, map[string]interface{}{"env": "stage"}, entry.Fields["context"])
+	assert.NotContains(t, buf.String(), "foo@bar.com")
+}
@@ -0,0 +1,386 @@
+package doorman
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mozilla.org/mozlogrus"
+)
+
+const (
+	// DefaultAuditFileMaxSize is the size (in bytes) above which audit files are rotated.
+	DefaultAuditFileMaxSize = 100 * 1024 * 1024
+	// DefaultAuditFileBackups is the number of rotated audit files that are kept.
+	DefaultAuditFileBackups = 10
+	// DefaultAuditBatchSize is the maximum number of entries sent at once to webhooks.
+	DefaultAuditBatchSize = 100
+	// DefaultAuditBatchInterval is the maximum delay before pending entries are sent to webhooks.
+	DefaultAuditBatchInterval = 5 * time.Second
+	// DefaultAuditWebhookQueue is the number of full batches that can wait to be sent
+	// to webhooks. Entries are dropped when the queue is full.
+	DefaultAuditWebhookQueue = 8
+	// DefaultAuditWebhookRetries is the number of times a failed batch is sent again.
+	DefaultAuditWebhookRetries = 3
+	// DefaultAuditWebhookBackoff is the delay before the first retry, doubled each time.
+	DefaultAuditWebhookBackoff = 1 * time.Second
+)
+
+// NewAuditSink instantiates the audit sink of the specified location:
+//
+//	stdout                        MozLog to standard output
+//	file:///var/log/audit.log     MozLog to a rotating file
+//	syslog                        MozLog to the local syslog
+//	https://audit.example.com/    batches of MozLog entries posted to a webhook
+func NewAuditSink(location string) (AuditSink, error) {
+	switch {
+	case location == "stdout":
+		return NewMozLogAuditSink(os.Stdout), nil
+	case location == "syslog":
+		s, err := NewSyslogAuditSink()
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case strings.HasPrefix(location, "file://"):
+		f, err := NewFileAuditSink(strings.TrimPrefix(location, "file://"), DefaultAuditFileMaxSize, DefaultAuditFileBackups)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://"):
+		return NewWebhookAuditSink(location, DefaultAuditBatchSize, DefaultAuditBatchInterval), nil
+	}
+	return nil, fmt.Errorf("unknown audit sink %q", location)
+}
+
+// SetAuditSinks replaces the sinks of the audit entries (default: MozLog to stdout).
+// The previous sinks are closed.
+func (doorman *LadonDoorman) SetAuditSinks(sinks ...AuditSink) {
+	doorman.auditLogger().setSinks(sinks)
+}
+
+// mozLog formats the entry following the Mozilla Log format (one JSON object per line).
+func mozLog(entry *AuditEntry) ([]byte, error) {
+	formatter := &mozlogrus.MozLogFormatter{LoggerName: "doorman", Type: entry.Type}
+	return formatter.Format(&logrus.Entry{
+		Time:  entry.Time,
+		Level: logrus.InfoLevel,
+		Data:  entry.Fields,
+	})
+}
+
+// MozLogAuditSink writes the entries following the Mozilla Log format.
+type MozLogAuditSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewMozLogAuditSink instantiates a sink that writes to the specified output.
+func NewMozLogAuditSink(out io.Writer) *MozLogAuditSink {
+	return &MozLogAuditSink{out: out}
+}
+
+// Write records the entry.
+func (m *MozLogAuditSink) Write(entry *AuditEntry) error {
+	b, err := mozLog(entry)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = m.out.Write(b)
+	return err
+}
+
+// Close does nothing, the output is left open.
+func (m *MozLogAuditSink) Close() error {
+	return nil
+}
+
+// FileAuditSink appends the entries to a file, which is rotated when it gets
+// bigger than the maximum size (eg. audit.log is renamed to audit.log.1).
+type FileAuditSink struct {
+	filename string
+	maxSize  int64
+	backups  int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileAuditSink opens (or creates) the file in append mode.
+func NewFileAuditSink(filename string, maxSize int64, backups int) (*FileAuditSink, error) {
+	f := &FileAuditSink{
+		filename: filename,
+		maxSize:  maxSize,
+		backups:  backups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileAuditSink) open() error {
+	file, err := os.OpenFile(f.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write records the entry, and rotates the file if it is full.
+func (f *FileAuditSink) Write(entry *AuditEntry) error {
+	b, err := mozLog(entry)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return fmt.Errorf("audit file %q is closed", f.filename)
+	}
+	if f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return err
+}
+
+// rotate shifts the backups (the oldest is removed) and starts a new file.
+func (f *FileAuditSink) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	backup := func(i int) string {
+		return fmt.Sprintf("%s.%d", f.filename, i)
+	}
+	if f.backups > 0 {
+		os.Remove(backup(f.backups))
+		for i := f.backups - 1; i > 0; i-- {
+			os.Rename(backup(i), backup(i+1))
+		}
+		if err := os.Rename(f.filename, backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.filename); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Close closes the file.
+func (f *FileAuditSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// WebhookAuditSink posts the entries to a URL, as JSON lists of MozLog objects.
+// The entries are sent in background when the batch is full, or periodically.
+// Failed batches are sent again with an exponential backoff. Writes never block:
+// if the webhook cannot keep up, the full batches are dropped and reported.
+type WebhookAuditSink struct {
+	// dropped is the number of entries that could not be queued or sent (first
+	// for the alignment of atomic operations).
+	dropped int64
+
+	url       string
+	client    *http.Client
+	batchSize int
+	retries   int
+	backoff   time.Duration
+
+	mu      sync.Mutex
+	pending []json.RawMessage
+
+	// closing prevents batches from being queued once closed.
+	closing sync.RWMutex
+	closed  bool
+
+	batches chan []json.RawMessage
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewWebhookAuditSink instantiates the sink and starts sending in background.
+func NewWebhookAuditSink(url string, batchSize int, interval time.Duration) *WebhookAuditSink {
+	w := &WebhookAuditSink{
+		url:       url,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		batchSize: batchSize,
+		retries:   DefaultAuditWebhookRetries,
+		backoff:   DefaultAuditWebhookBackoff,
+		batches:   make(chan []json.RawMessage, DefaultAuditWebhookQueue),
+		done:      make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run(interval)
+	return w
+}
+
+// Write adds the entry to the pending batch. It returns an error if the batch was
+// full and could not be queued.
+func (w *WebhookAuditSink) Write(entry *AuditEntry) error {
+	b, err := mozLog(entry)
+	if err != nil {
+		return err
+	}
+	w.closing.RLock()
+	defer w.closing.RUnlock()
+	if w.closed {
+		return fmt.Errorf("audit webhook %q is closed", w.url)
+	}
+
+	w.mu.Lock()
+	w.pending = append(w.pending, json.RawMessage(bytes.TrimSpace(b)))
+	var batch []json.RawMessage
+	if len(w.pending) >= w.batchSize {
+		batch = w.take()
+	}
+	w.mu.Unlock()
+
+	if batch != nil {
+		select {
+		case w.batches <- batch:
+		default:
+			atomic.AddInt64(&w.dropped, int64(len(batch)))
+			return fmt.Errorf("audit webhook %q is overloaded, %d entries dropped", w.url, len(batch))
+		}
+	}
+	return nil
+}
+
+// Dropped returns the number of entries that could not be queued or sent.
+func (w *WebhookAuditSink) Dropped() int64 {
+	return atomic.LoadInt64(&w.dropped)
+}
+
+// take returns the pending entries. The lock must be held.
+func (w *WebhookAuditSink) take() []json.RawMessage {
+	batch := w.pending
+	w.pending = nil
+	return batch
+}
+
+func (w *WebhookAuditSink) run(interval time.Duration) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case batch := <-w.batches:
+			w.post(batch)
+		case <-ticker.C:
+			w.mu.Lock()
+			batch := w.take()
+			w.mu.Unlock()
+			w.post(batch)
+		case <-w.done:
+			// Send what is left.
+			for {
+				select {
+				case batch := <-w.batches:
+					w.post(batch)
+				default:
+					w.mu.Lock()
+					batch := w.take()
+					w.mu.Unlock()
+					w.post(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// post sends the batch, and retries with an exponential backoff if it fails. The
+// batch is dropped after the last retry, or if the sink is closed meanwhile.
+func (w *WebhookAuditSink) post(batch []json.RawMessage) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		logrus.Errorf("Could not encode audit entries: %s", err)
+		atomic.AddInt64(&w.dropped, int64(len(batch)))
+		return
+	}
+	delay := w.backoff
+	for attempt := 0; ; attempt++ {
+		err = w.send(body)
+		if err == nil {
+			return
+		}
+		if attempt >= w.retries {
+			break
+		}
+		logrus.Warningf("Could not send %d audit entries to %q, retrying in %s: %s", len(batch), w.url, delay, err)
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-w.done:
+			// Do not delay the shutdown.
+			attempt = w.retries
+		}
+	}
+	logrus.Errorf("Could not send %d audit entries to %q: %s", len(batch), w.url, err)
+	atomic.AddInt64(&w.dropped, int64(len(batch)))
+}
+
+// send posts the encoded batch once.
+func (w *WebhookAuditSink) send(body []byte) error {
+	response, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s", response.Status)
+	}
+	return nil
+}
+
+// Close sends the pending entries and stops.
+func (w *WebhookAuditSink) Close() error {
+	w.closing.Lock()
+	if w.closed {
+		w.closing.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.closing.Unlock()
+
+	close(w.done)
+	w.wg.Wait()
+	return nil
+}
@@ -0,0 +1,224 @@
+package doorman
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleAuditEntry(action string) *AuditEntry {
+	return &AuditEntry{
+		Time: time.Date(2018, 1, 31, 12, 0, 0, 0, time.UTC),
+		Type: AuditAuthorization,
+		Fields: map[string]interface{}{
+			"allowed": true,
+			"action":  action,
+		},
+	}
+}
+
+func TestNewAuditSink(t *testing.T) {
+	sink, err := NewAuditSink("stdout")
+	require.Nil(t, err)
+	assert.IsType(t, &MozLogAuditSink{}, sink)
+
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	sink, err = NewAuditSink("file://" + filepath.Join(dir, "audit.log"))
+	require.Nil(t, err)
+	assert.IsType(t, &FileAuditSink{}, sink)
+	sink.Close()
+
+	_, err = NewAuditSink("file://" + filepath.Join(dir, "unknown", "audit.log"))
+	assert.NotNil(t, err)
+
+	sink, err = NewAuditSink("https://audit.example.com")
+	require.Nil(t, err)
+	assert.IsType(t, &WebhookAuditSink{}, sink)
+	sink.Close()
+
+	_, err = NewAuditSink("kafka://broker")
+	assert.Equal(t, "unknown audit sink \"kafka://broker\"", err.Error())
+}
+
+func TestMozLogAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewMozLogAuditSink(&buf)
+	require.Nil(t, sink.Write(sampleAuditEntry("read")))
+
+	var logged map[string]interface{}
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &logged))
+	assert.Equal(t, "request.authorization", logged["Type"])
+	assert.Equal(t, "read", logged["Fields"].(map[string]interface{})["action"])
+}
+
+func TestSetAuditSinks(t *testing.T) {
+	var first, second bytes.Buffer
+	doorman := sampleDoorman()
+	doorman.SetAuditSinks(NewMozLogAuditSink(&first), NewMozLogAuditSink(&second))
+
+	doorman.IsAllowed("https://sample.yaml", &Request{})
+	assert.Contains(t, first.String(), "\"allowed\":false")
+	assert.Equal(t, first.String(), second.String())
+}
+
+func TestFileAuditSink(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "audit.log")
+
+	line, _ := mozLog(sampleAuditEntry("read"))
+	// Rotate every two entries, keep two backups.
+	sink, err := NewFileAuditSink(filename, int64(2*len(line)), 2)
+	require.Nil(t, err)
+	for i := 0; i < 7; i++ {
+		require.Nil(t, sink.Write(sampleAuditEntry("read")))
+	}
+	require.Nil(t, sink.Close())
+
+	lines := func(name string) int {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			return -1
+		}
+		return strings.Count(string(content), "\n")
+	}
+	assert.Equal(t, 1, lines(filename))
+	assert.Equal(t, 2, lines(filename+".1"))
+	assert.Equal(t, 2, lines(filename+".2"))
+	assert.Equal(t, -1, lines(filename+".3"))
+
+	// Appended when reopened.
+	sink, err = NewFileAuditSink(filename, DefaultAuditFileMaxSize, 2)
+	require.Nil(t, err)
+	sink.Write(sampleAuditEntry("read"))
+	sink.Close()
+	assert.Equal(t, 2, lines(filename))
+
+	assert.NotNil(t, sink.Write(sampleAuditEntry("read")))
+}
+
+func TestWebhookAuditSink(t *testing.T) {
+	var mu sync.Mutex
+	batches := [][]map[string]interface{}{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	sink := NewWebhookAuditSink(ts.URL, 2, time.Hour)
+	for _, action := range []string{"a", "b", "c"} {
+		require.Nil(t, sink.Write(sampleAuditEntry(action)))
+	}
+	// Pending entries are sent on close.
+	require.Nil(t, sink.Close())
+	assert.NotNil(t, sink.Write(sampleAuditEntry("d")))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 1)
+	assert.Equal(t, "c", batches[1][0]["Fields"].(map[string]interface{})["action"])
+}
+
+func TestWebhookAuditSinkInterval(t *testing.T) {
+	sent := make(chan int, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&batch)
+		sent <- len(batch)
+	}))
+	defer ts.Close()
+
+	sink := NewWebhookAuditSink(ts.URL, 100, 10*time.Millisecond)
+	defer sink.Close()
+	sink.Write(sampleAuditEntry("a"))
+
+	select {
+	case n := <-sent:
+		assert.Equal(t, 1, n)
+	case <-time.After(time.Second):
+		t.Fatal("entries were not sent")
+	}
+}
+
+func TestWebhookAuditSinkRetry(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	sent := make(chan int, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		failed := attempts <= 2
+		mu.Unlock()
+		if failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&batch)
+		sent <- len(batch)
+	}))
+	defer ts.Close()
+
+	sink := NewWebhookAuditSink(ts.URL, 1, time.Hour)
+	sink.backoff = time.Millisecond
+	defer sink.Close()
+	require.Nil(t, sink.Write(sampleAuditEntry("a")))
+
+	select {
+	case n := <-sent:
+		assert.Equal(t, 1, n)
+	case <-time.After(time.Second):
+		t.Fatal("entries were not sent again")
+	}
+	assert.Equal(t, int64(0), sink.Dropped())
+
+	// Dropped after the last retry.
+	sink.url = "http://127.0.0.1:0/"
+	require.Nil(t, sink.Write(sampleAuditEntry("b")))
+	require.Nil(t, sink.Close())
+	assert.Equal(t, int64(1), sink.Dropped())
+}
+
+func TestWebhookAuditSinkOverflow(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+
+	sink := NewWebhookAuditSink(ts.URL, 1, time.Hour)
+
+	// Writes do not block while the webhook is stuck.
+	var err error
+	for i := 0; i < DefaultAuditWebhookQueue+2 && err == nil; i++ {
+		err = sink.Write(sampleAuditEntry("a"))
+		if err == nil {
+			// Let the first batch be taken from the queue.
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is overloaded, 1 entries dropped")
+	assert.Equal(t, int64(1), sink.Dropped())
+
+	close(release)
+	require.Nil(t, sink.Close())
+}
@@ -0,0 +1,37 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package doorman
+
+import (
+	"bytes"
+	"log/syslog"
+)
+
+// SyslogAuditSink sends the entries to the local syslog, following the Mozilla Log format.
+type SyslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogAuditSink connects to the local syslog socket.
+func NewSyslogAuditSink() (*SyslogAuditSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "doorman")
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogAuditSink{writer: w}, nil
+}
+
+// Write records the entry.
+func (s *SyslogAuditSink) Write(entry *AuditEntry) error {
+	b, err := mozLog(entry)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(bytes.TrimSpace(b)))
+}
+
+// Close closes the connection.
+func (s *SyslogAuditSink) Close() error {
+	return s.writer.Close()
+}
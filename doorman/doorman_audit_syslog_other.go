@@ -0,0 +1,26 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package doorman
+
+import (
+	"fmt"
+)
+
+// SyslogAuditSink is not supported on this platform.
+type SyslogAuditSink struct{}
+
+// NewSyslogAuditSink returns an error, syslog is not supported on this platform.
+func NewSyslogAuditSink() (*SyslogAuditSink, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
+
+// Write does nothing.
+func (s *SyslogAuditSink) Write(entry *AuditEntry) error {
+	return nil
+}
+
+// Close does nothing.
+func (s *SyslogAuditSink) Close() error {
+	return nil
+}
@@ -0,0 +1,113 @@
+package doorman
+
+import (
+	"github.com/ory/ladon"
+)
+
+const (
+	// DenyOverrides denies the request if any of the matching policies denies it.
+	DenyOverrides = "deny-overrides"
+	// PermitOverrides allows the request if any of the matching policies allows it.
+	PermitOverrides = "permit-overrides"
+	// FirstApplicable uses the first matching policy, in the order of the policies file.
+	FirstApplicable = "first-applicable"
+)
+
+// detailedPolicy is a Ladon policy with the details returned in its decisions.
+type detailedPolicy struct {
+	*ladon.DefaultPolicy
+	reason      string
+	obligations map[string]interface{}
+	advice      map[string]interface{}
+}
+
+// match is a policy that applies to a request for one of its principals.
+type match struct {
+	policy    ladon.Policy
+	principal string
+}
+
+// policyIndex looks up the policies of a service that apply to a request.
+type policyIndex interface {
+	// match returns the policies that apply to the request for one of the
+	// principals, in the order of the configuration.
+	match(principals Principals, r *ladon.Request) []match
+}
+
+// combine returns the decision of the matching policies using the specified algorithm.
+func combine(algorithm string, matches []match) *Decision {
+	var allows, denies []match
+	for _, m := range matches {
+		if m.policy.AllowAccess() {
+			allows = append(allows, m)
+		} else {
+			denies = append(denies, m)
+		}
+	}
+
+	switch algorithm {
+	case PermitOverrides:
+		if len(allows) > 0 {
+			return newDecision(true, allows)
+		}
+		if len(denies) > 0 {
+			return newDecision(false, denies)
+		}
+	case FirstApplicable:
+		if len(matches) > 0 {
+			return newDecision(matches[0].policy.AllowAccess(), matches[:1])
+		}
+	default:
+		if len(denies) > 0 {
+			return newDecision(false, denies)
+		}
+		if len(allows) > 0 {
+			return newDecision(true, allows)
+		}
+	}
+	return &Decision{
+		Policies: []string{},
+		Reason:   ReasonNoMatch,
+	}
+}
+
+// newDecision returns a decision explained by the specified deciding policies.
+func newDecision(allowed bool, deciders []match) *Decision {
+	decision := &Decision{
+		Allowed:   allowed,
+		Policies:  []string{},
+		Principal: deciders[0].principal,
+		Reason:    ReasonExplicitDeny,
+	}
+	if allowed {
+		decision.Reason = ReasonAllowed
+	}
+	for _, m := range deciders {
+		decision.Policies = append(decision.Policies, m.policy.GetID())
+
+		// Details of the deciding policies (the first one wins for a given key).
+		p, ok := m.policy.(*detailedPolicy)
+		if !ok {
+			continue
+		}
+		if p.reason != "" {
+			decision.Messages = append(decision.Messages, p.reason)
+		}
+		decision.Obligations = mergeDetails(decision.Obligations, p.obligations)
+		decision.Advice = mergeDetails(decision.Advice, p.advice)
+	}
+	return decision
+}
+
+// mergeDetails adds the details that are not already set.
+func mergeDetails(details map[string]interface{}, other map[string]interface{}) map[string]interface{} {
+	for key, value := range other {
+		if details == nil {
+			details = map[string]interface{}{}
+		}
+		if _, ok := details[key]; !ok {
+			details[key] = value
+		}
+	}
+	return details
+}
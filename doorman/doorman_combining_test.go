@@ -0,0 +1,198 @@
+package doorman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func combiningDoorman(t *testing.T, algorithm string) *LadonDoorman {
+	doorman := NewDefaultLadon()
+	err := doorman.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service:            "https://combining.yaml",
+			CombiningAlgorithm: algorithm,
+			Policies: Policies{
+				Policy{
+					ID:         "bob-cannot-publish",
+					Principals: Principals{"userid:bob"},
+					Actions:    []string{"publish"},
+					Resources:  []string{"<.*>"},
+					Effect:     "deny",
+				},
+				Policy{
+					ID:         "editors-publish",
+					Principals: Principals{"group:editors"},
+					Actions:    []string{"publish", "read"},
+					Resources:  []string{"<.*>"},
+					Effect:     "allow",
+				},
+				Policy{
+					ID:         "nobody-reads-drafts",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"read"},
+					Resources:  []string{"draft"},
+					Effect:     "deny",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+	return doorman
+}
+
+func TestDenyOverrides(t *testing.T) {
+	for _, algorithm := range []string{"", DenyOverrides} {
+		doorman := combiningDoorman(t, algorithm)
+
+		// Bob is denied even if one of his other principals is allowed.
+		decision := doorman.IsAllowed("https://combining.yaml", &Request{
+			Principals: Principals{"group:editors", "userid:bob"},
+			Action:     "publish",
+			Resource:   "article",
+		})
+		assert.False(t, decision.Allowed)
+		assert.Equal(t, ReasonExplicitDeny, decision.Reason)
+		assert.Equal(t, []string{"bob-cannot-publish"}, decision.Policies)
+		assert.Equal(t, "userid:bob", decision.Principal)
+
+		// Other editors are allowed.
+		decision = doorman.IsAllowed("https://combining.yaml", &Request{
+			Principals: Principals{"userid:alice", "group:editors"},
+			Action:     "publish",
+			Resource:   "article",
+		})
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, "group:editors", decision.Principal)
+	}
+}
+
+func TestPermitOverrides(t *testing.T) {
+	doorman := combiningDoorman(t, PermitOverrides)
+
+	decision := doorman.IsAllowed("https://combining.yaml", &Request{
+		Principals: Principals{"userid:bob", "group:editors"},
+		Action:     "publish",
+		Resource:   "article",
+	})
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, []string{"editors-publish"}, decision.Policies)
+
+	decision = doorman.IsAllowed("https://combining.yaml", &Request{
+		Principals: Principals{"userid:bob"},
+		Action:     "publish",
+		Resource:   "article",
+	})
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, ReasonExplicitDeny, decision.Reason)
+}
+
+func TestFirstApplicable(t *testing.T) {
+	doorman := combiningDoorman(t, FirstApplicable)
+
+	// The deny policy comes first.
+	decision := doorman.IsAllowed("https://combining.yaml", &Request{
+		Principals: Principals{"group:editors", "userid:bob"},
+		Action:     "publish",
+		Resource:   "article",
+	})
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, []string{"bob-cannot-publish"}, decision.Policies)
+
+	// The allow policy comes first.
+	decision = doorman.IsAllowed("https://combining.yaml", &Request{
+		Principals: Principals{"group:editors"},
+		Action:     "read",
+		Resource:   "draft",
+	})
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, []string{"editors-publish"}, decision.Policies)
+
+	// Nothing applies.
+	decision = doorman.IsAllowed("https://combining.yaml", &Request{
+		Principals: Principals{"userid:alice"},
+		Action:     "publish",
+		Resource:   "article",
+	})
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, ReasonNoMatch, decision.Reason)
+}
+
+func TestDecisionDetails(t *testing.T) {
+	doorman := NewDefaultLadon()
+	err := doorman.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "https://details.yaml",
+			Policies: Policies{
+				Policy{
+					ID:          "editors-publish",
+					Principals:  Principals{"group:editors"},
+					Actions:     []string{"publish"},
+					Resources:   []string{"<.*>"},
+					Effect:      "allow",
+					Obligations: map[string]interface{}{"requireMFA": true},
+					Advice: map[string]interface{}{
+						// Nested maps as decoded from YAML.
+						"maskFields": map[interface{}]interface{}{"profile": []interface{}{"email"}},
+					},
+				},
+				Policy{
+					ID:          "no-publish-on-friday",
+					Principals:  Principals{"<.*>"},
+					Actions:     []string{"publish"},
+					Resources:   []string{"<.*>"},
+					Effect:      "deny",
+					Reason:      "Do not publish on Friday",
+					Obligations: map[string]interface{}{"notify": "ops"},
+					Conditions: Conditions{
+						"day": Condition{
+							Type:    "StringEqualCondition",
+							Options: map[string]interface{}{"equals": "friday"},
+						},
+					},
+				},
+				Policy{
+					ID:          "freeze",
+					Principals:  Principals{"<.*>"},
+					Actions:     []string{"publish"},
+					Resources:   []string{"<.*>"},
+					Effect:      "deny",
+					Reason:      "Release freeze",
+					Obligations: map[string]interface{}{"notify": "release-managers", "ticket": true},
+					Conditions: Conditions{
+						"freeze": Condition{
+							Type:    "BooleanEqualCondition",
+							Options: map[string]interface{}{"equals": true},
+						},
+					},
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	decision := doorman.IsAllowed("https://details.yaml", &Request{
+		Principals: Principals{"group:editors"},
+		Action:     "publish",
+		Resource:   "article",
+	})
+	assert.True(t, decision.Allowed)
+	assert.Nil(t, decision.Messages)
+	assert.Equal(t, map[string]interface{}{"requireMFA": true}, decision.Obligations)
+	assert.Equal(t, map[string]interface{}{
+		"maskFields": map[string]interface{}{"profile": []interface{}{"email"}},
+	}, decision.Advice)
+
+	// Details of every deciding policy, the first one wins.
+	decision = doorman.IsAllowed("https://details.yaml", &Request{
+		Principals: Principals{"group:editors"},
+		Action:     "publish",
+		Resource:   "article",
+		Context:    Context{"day": "friday", "freeze": true},
+	})
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, []string{"Do not publish on Friday", "Release freeze"}, decision.Messages)
+	assert.Equal(t, map[string]interface{}{"notify": "ops", "ticket": true}, decision.Obligations)
+	assert.Nil(t, decision.Advice)
+}
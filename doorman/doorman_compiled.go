@@ -0,0 +1,203 @@
+package doorman
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ory/ladon"
+	"github.com/ory/ladon/compiler"
+)
+
+// CompiledDoorman is a Doorman that compiles the policies into lookup indexes
+// when they are loaded, instead of scanning every policy with Ladon for each request.
+//
+// Literal actions, resources and principals are indexed, and the regular expressions
+// are compiled once. It gives the same decisions as LadonDoorman.
+type CompiledDoorman struct {
+	*LadonDoorman
+}
+
+// NewCompiledDoorman instantiates a new compiled doorman.
+func NewCompiledDoorman() *CompiledDoorman {
+	d := NewDefaultLadon()
+	d.newIndex = newCompiledIndex
+	return &CompiledDoorman{d}
+}
+
+// patterns is a compiled list of policy values (actions, resources or principals).
+type patterns struct {
+	literals map[string]bool
+	regexps  []*regexp.Regexp
+}
+
+func compilePatterns(policy ladon.Policy, values []string) (*patterns, error) {
+	where := fmt.Sprintf("policy %q", policy.GetID())
+	return newPatterns(values, policy.GetStartDelimiter(), policy.GetEndDelimiter(), where)
+}
+
+// newPatterns compiles the values. The location of the values is used in errors.
+func newPatterns(values []string, start, end byte, where string) (*patterns, error) {
+	p := &patterns{
+		literals: map[string]bool{},
+	}
+	for _, value := range values {
+		if !strings.Contains(value, string(start)) {
+			p.literals[value] = true
+			continue
+		}
+		r, err := compiler.CompileRegex(value, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s: %s", value, where, err)
+		}
+		p.regexps = append(p.regexps, r)
+	}
+	return p, nil
+}
+
+// matches returns true if the value is one of the literals or matches a regexp.
+func (p *patterns) matches(value string) bool {
+	if p.literals[value] {
+		return true
+	}
+	for _, r := range p.regexps {
+		if r.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+type compiledPolicy struct {
+	policy     ladon.Policy
+	actions    *patterns
+	resources  *patterns
+	principals *patterns
+}
+
+// valueIndex maps literal values to the positions of the policies that contain them.
+// The positions of policies with regular expressions are kept aside.
+type valueIndex struct {
+	literals map[string][]int
+	patterns []int
+}
+
+func newValueIndex() *valueIndex {
+	return &valueIndex{
+		literals: map[string][]int{},
+	}
+}
+
+func (v *valueIndex) add(position int, p *patterns) {
+	for literal := range p.literals {
+		v.literals[literal] = append(v.literals[literal], position)
+	}
+	if len(p.regexps) > 0 {
+		v.patterns = append(v.patterns, position)
+	}
+}
+
+// compiledIndex looks up the candidate policies by action, resource and principals,
+// and only evaluates the conditions of those.
+type compiledIndex struct {
+	policies   []*compiledPolicy
+	actions    *valueIndex
+	resources  *valueIndex
+	principals *valueIndex
+}
+
+func newCompiledIndex(policies ladon.Policies) (policyIndex, error) {
+	idx := &compiledIndex{
+		actions:    newValueIndex(),
+		resources:  newValueIndex(),
+		principals: newValueIndex(),
+	}
+	for position, policy := range policies {
+		actions, err := compilePatterns(policy, policy.GetActions())
+		if err != nil {
+			return nil, err
+		}
+		resources, err := compilePatterns(policy, policy.GetResources())
+		if err != nil {
+			return nil, err
+		}
+		principals, err := compilePatterns(policy, policy.GetSubjects())
+		if err != nil {
+			return nil, err
+		}
+		idx.policies = append(idx.policies, &compiledPolicy{
+			policy:     policy,
+			actions:    actions,
+			resources:  resources,
+			principals: principals,
+		})
+		idx.actions.add(position, actions)
+		idx.resources.add(position, resources)
+		idx.principals.add(position, principals)
+	}
+	return idx, nil
+}
+
+const (
+	actionHit   = 1
+	resourceHit = 2
+)
+
+func (idx *compiledIndex) match(principals Principals, r *ladon.Request) []match {
+	// Flag the policies that match the action and the resource.
+	hits := make([]uint8, len(idx.policies))
+	for _, position := range idx.actions.literals[r.Action] {
+		hits[position] |= actionHit
+	}
+	for _, position := range idx.actions.patterns {
+		if idx.policies[position].actions.matches(r.Action) {
+			hits[position] |= actionHit
+		}
+	}
+	for _, position := range idx.resources.literals[r.Resource] {
+		hits[position] |= resourceHit
+	}
+	for _, position := range idx.resources.patterns {
+		if hits[position]&actionHit != 0 && idx.policies[position].resources.matches(r.Resource) {
+			hits[position] |= resourceHit
+		}
+	}
+
+	// Principals of the request that are literals of the candidate policies, in the
+	// order of the request.
+	literals := make([][]int, len(idx.policies))
+	for i, principal := range principals {
+		for _, position := range idx.principals.literals[principal] {
+			if hits[position] == actionHit|resourceHit {
+				literals[position] = append(literals[position], i)
+			}
+		}
+	}
+
+	matches := []match{}
+	for position, hit := range hits {
+		if hit != actionHit|resourceHit {
+			continue
+		}
+		compiled := idx.policies[position]
+		candidates := literals[position]
+		if len(compiled.principals.regexps) > 0 {
+			// Regular expressions are matched against every principal.
+			candidates = nil
+			for i, principal := range principals {
+				if compiled.principals.matches(principal) {
+					candidates = append(candidates, i)
+				}
+			}
+		}
+		for _, i := range candidates {
+			// Some conditions depend on the subject (eg. MatchPrincipalsCondition).
+			r.Subject = principals[i]
+			if fulfills(compiled.policy.GetConditions(), &evaluation{request: r, principals: principals}) {
+				matches = append(matches, match{policy: compiled.policy, principal: principals[i]})
+				break
+			}
+		}
+	}
+	return matches
+}
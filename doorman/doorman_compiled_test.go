@@ -0,0 +1,164 @@
+package doorman
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ory/ladon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleCompiledDoorman() *CompiledDoorman {
+	doorman := NewCompiledDoorman()
+	doorman.LoadPolicies(sampleConfigs)
+	return doorman
+}
+
+func TestCompiledSameDecisions(t *testing.T) {
+	compiled := sampleCompiledDoorman()
+	scanning := sampleDoorman()
+
+	service := "https://sample.yaml"
+
+	for _, request := range sampleAllowedRequests() {
+		decision := compiled.IsAllowed(service, request)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, scanning.IsAllowed(service, request), decision)
+	}
+	for _, request := range sampleNotAllowedRequests() {
+		decision := compiled.IsAllowed(service, request)
+		assert.False(t, decision.Allowed)
+		assert.Equal(t, scanning.IsAllowed(service, request), decision)
+	}
+}
+
+func TestCompiledExpandPrincipals(t *testing.T) {
+	doorman := sampleCompiledDoorman()
+
+	principals := doorman.ExpandPrincipals("https://sample.yaml", Principals{"userid:maria"})
+	assert.Equal(t, principals, Principals{"userid:maria", "tag:admins"})
+}
+
+func TestCompiledBadPattern(t *testing.T) {
+	doorman := NewCompiledDoorman()
+	err := doorman.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:        "1",
+					Actions:   []string{"<[a-z>"},
+					Resources: []string{"<.*>"},
+					Effect:    "allow",
+				},
+			},
+		},
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid pattern \"<[a-z>\" in policy \"1\"")
+}
+
+// benchmarkConfigs returns a service with hundreds of policies, whose principals
+// are a mix of groups, users and patterns.
+func benchmarkConfigs() ServicesConfig {
+	policies := Policies{}
+	for i := 0; i < 300; i++ {
+		policy := Policy{
+			ID:         fmt.Sprintf("%d", i),
+			Principals: Principals{fmt.Sprintf("group:team-%d", i%50), fmt.Sprintf("userid:user-%d", i)},
+			Actions:    []string{fmt.Sprintf("action-%d", i%20)},
+			Resources:  []string{fmt.Sprintf("resource-%d", i%30)},
+			Effect:     "allow",
+		}
+		if i%10 == 0 {
+			policy.Resources = []string{fmt.Sprintf("resource-%d/<.*>", i)}
+		}
+		if i%25 == 0 {
+			policy.Principals = Principals{"email:<.*>@mozilla.com"}
+			policy.Effect = "deny"
+		}
+		policies = append(policies, policy)
+	}
+	return ServicesConfig{
+		ServiceConfig{
+			Service:  "https://bench.yaml",
+			Policies: policies,
+		},
+	}
+}
+
+func benchmarkRequest() *Request {
+	principals := Principals{"userid:user-42", "email:user-42@corp.com"}
+	for i := 0; i < 40; i++ {
+		principals = append(principals, fmt.Sprintf("group:team-%d", i))
+	}
+	return &Request{
+		Principals: principals,
+		Action:     "action-7",
+		Resource:   "resource-17",
+	}
+}
+
+// benchmarkMatch measures the lookup of the policies that apply to the request,
+// leaving out the audit logging.
+func benchmarkMatch(b *testing.B, doorman *LadonDoorman) {
+	doorman.LoadPolicies(benchmarkConfigs())
+	index := doorman.snapshot().indexes["https://bench.yaml"]
+
+	request := benchmarkRequest()
+	r := &ladon.Request{
+		Action:   request.Action,
+		Resource: request.Resource,
+		Context:  ladon.Context{},
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.match(request.Principals, r)
+	}
+}
+
+func BenchmarkLadonMatch(b *testing.B) {
+	benchmarkMatch(b, NewDefaultLadon())
+}
+
+func BenchmarkCompiledMatch(b *testing.B) {
+	benchmarkMatch(b, NewCompiledDoorman().LadonDoorman)
+}
+
+func BenchmarkCompiledIsAllowed(b *testing.B) {
+	doorman := NewCompiledDoorman()
+	doorman.LoadPolicies(benchmarkConfigs())
+	doorman.SetAuditSinks(NewMozLogAuditSink(ioutil.Discard))
+
+	request := benchmarkRequest()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		doorman.IsAllowed("https://bench.yaml", request)
+	}
+}
+
+func TestCompiledPrincipalsIndex(t *testing.T) {
+	doorman := NewCompiledDoorman()
+	err := doorman.LoadPolicies(benchmarkConfigs())
+	require.Nil(t, err)
+	idx := doorman.snapshot().indexes["https://bench.yaml"].(*compiledIndex)
+
+	assert.Equal(t, []int{42}, idx.principals.literals["userid:user-42"])
+	assert.Equal(t, []int{7, 57, 107, 157, 207, 257}, idx.principals.literals["group:team-7"])
+	assert.Len(t, idx.principals.patterns, 12)
+
+	scanning := NewDefaultLadon()
+	scanning.LoadPolicies(benchmarkConfigs())
+	for _, request := range []*Request{
+		benchmarkRequest(),
+		{Principals: Principals{"group:team-3", "userid:user-103"}, Action: "action-3", Resource: "resource-13"},
+		{Principals: Principals{"email:alice@mozilla.com", "group:team-0"}, Action: "action-0", Resource: "resource-0/a"},
+	} {
+		s := doorman.snapshot()
+		expected, _ := scanning.snapshot().evaluate(scanning.snapshot().indexes["https://bench.yaml"], "https://bench.yaml", request.Principals, ladonRequest(request))
+		decision, _ := s.evaluate(s.indexes["https://bench.yaml"], "https://bench.yaml", request.Principals, ladonRequest(request))
+		assert.Equal(t, expected, decision)
+	}
+}
@@ -0,0 +1,228 @@
+package doorman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var sampleHierarchyConfigs = ServicesConfig{
+	ServiceConfig{
+		Service:           "https://hierarchy.yaml",
+		ResourceSeparator: "/",
+		Policies: Policies{
+			Policy{
+				ID:         "1",
+				Principals: Principals{"userid:foo"},
+				Actions:    []string{"read", "write"},
+				Resources:  []string{"bucket:main"},
+				Effect:     "allow",
+			},
+			Policy{
+				ID:         "2",
+				Principals: Principals{"<.*>"},
+				Actions:    []string{"write"},
+				Resources:  []string{"bucket:main/collection:addons"},
+				Effect:     "deny",
+			},
+			Policy{
+				ID:         "3",
+				Principals: Principals{"userid:foo"},
+				Actions:    []string{"write"},
+				Resources:  []string{"bucket:main/collection:addons/record:42"},
+				Effect:     "allow",
+			},
+			Policy{
+				ID:         "4",
+				Principals: Principals{"userid:bar"},
+				Actions:    []string{"read"},
+				Resources:  []string{"bucket:<[^/]+>/collection:certs"},
+				Effect:     "allow",
+			},
+		},
+	},
+}
+
+func sampleHierarchyDoorman(t *testing.T) *LadonDoorman {
+	doorman := NewDefaultLadon()
+	require.Nil(t, doorman.LoadPolicies(sampleHierarchyConfigs))
+	return doorman
+}
+
+func sampleHierarchyCompiledDoorman(t *testing.T) *LadonDoorman {
+	doorman := NewCompiledDoorman()
+	require.Nil(t, doorman.LoadPolicies(sampleHierarchyConfigs))
+	return doorman.LadonDoorman
+}
+
+// sampleHierarchyAllowedRequests are allowed by the sample hierarchy policies.
+func sampleHierarchyAllowedRequests() []*Request {
+	return []*Request{
+		// Policy #1
+		{
+			Principals: []string{"userid:foo"},
+			Action:     "read",
+			Resource:   "bucket:main",
+		},
+		// Policy #1 (inherited)
+		{
+			Principals: []string{"userid:foo"},
+			Action:     "read",
+			Resource:   "bucket:main/collection:addons/record:1",
+		},
+		// Policy #1 (sibling of denied collection)
+		{
+			Principals: []string{"userid:foo"},
+			Action:     "write",
+			Resource:   "bucket:main/collection:plugins",
+		},
+		// Policy #4 (regular expression, inherited)
+		{
+			Principals: []string{"userid:bar"},
+			Action:     "read",
+			Resource:   "bucket:security/collection:certs/record:1",
+		},
+	}
+}
+
+func TestHierarchyAllowed(t *testing.T) {
+	for _, doorman := range []*LadonDoorman{sampleHierarchyDoorman(t), sampleHierarchyCompiledDoorman(t)} {
+		for _, request := range sampleHierarchyAllowedRequests() {
+			assert.Equal(t, true, doorman.IsAllowed("https://hierarchy.yaml", request).Allowed, request.Resource)
+		}
+	}
+}
+
+// sampleHierarchyNotAllowedRequests are denied by the sample hierarchy policies.
+func sampleHierarchyNotAllowedRequests() []*Request {
+	return []*Request{
+		// Policy #1 (not a descendant)
+		{
+			Principals: []string{"userid:foo"},
+			Action:     "read",
+			Resource:   "bucket:mainstream",
+		},
+		// Policy #2
+		{
+			Principals: []string{"userid:foo"},
+			Action:     "write",
+			Resource:   "bucket:main/collection:addons",
+		},
+		// Policy #2 (inherited)
+		{
+			Principals: []string{"userid:foo"},
+			Action:     "write",
+			Resource:   "bucket:main/collection:addons/record:1",
+		},
+		// Policy #2 (inherited, overrides the more specific policy #3)
+		{
+			Principals: []string{"userid:foo"},
+			Action:     "write",
+			Resource:   "bucket:main/collection:addons/record:42",
+		},
+		// Policy #4 (ancestor)
+		{
+			Principals: []string{"userid:bar"},
+			Action:     "read",
+			Resource:   "bucket:security",
+		},
+		// Default
+		{
+			Principals: []string{"userid:bar"},
+			Action:     "read",
+			Resource:   "bucket:main/collection:addons",
+		},
+	}
+}
+
+func TestHierarchyNotAllowed(t *testing.T) {
+	for _, doorman := range []*LadonDoorman{sampleHierarchyDoorman(t), sampleHierarchyCompiledDoorman(t)} {
+		for _, request := range sampleHierarchyNotAllowedRequests() {
+			assert.Equal(t, false, doorman.IsAllowed("https://hierarchy.yaml", request).Allowed, request.Resource)
+		}
+	}
+}
+
+func TestHierarchyDecision(t *testing.T) {
+	doorman := sampleHierarchyDoorman(t)
+
+	decision := doorman.IsAllowed("https://hierarchy.yaml", &Request{
+		Principals: []string{"userid:foo"},
+		Action:     "write",
+		Resource:   "bucket:main/collection:addons/record:1",
+	})
+	assert.Equal(t, []string{"2"}, decision.Policies)
+	assert.Equal(t, ReasonExplicitDeny, decision.Reason)
+}
+
+func TestHierarchyCombiningAlgorithms(t *testing.T) {
+	request := &Request{
+		Principals: []string{"userid:foo"},
+		Action:     "write",
+		Resource:   "bucket:main/collection:addons/record:42",
+	}
+	for _, test := range []struct {
+		algorithm string
+		allowed   bool
+		policies  []string
+	}{
+		{DenyOverrides, false, []string{"2"}},
+		{PermitOverrides, true, []string{"3", "1"}},
+		// The policies of the most specific levels come first.
+		{FirstApplicable, true, []string{"3"}},
+	} {
+		configs := ServicesConfig{sampleHierarchyConfigs[0]}
+		configs[0].CombiningAlgorithm = test.algorithm
+		doorman := NewDefaultLadon()
+		require.Nil(t, doorman.LoadPolicies(configs))
+
+		decision := doorman.IsAllowed("https://hierarchy.yaml", request)
+		assert.Equal(t, test.allowed, decision.Allowed, test.algorithm)
+		assert.Equal(t, test.policies, decision.Policies, test.algorithm)
+	}
+}
+
+func TestHierarchyFlat(t *testing.T) {
+	configs := ServicesConfig{sampleHierarchyConfigs[0]}
+	configs[0].ResourceSeparator = ""
+	doorman := NewDefaultLadon()
+	require.Nil(t, doorman.LoadPolicies(configs))
+
+	// Without separator, policies on parents do not apply.
+	decision := doorman.IsAllowed("https://hierarchy.yaml", &Request{
+		Principals: []string{"userid:foo"},
+		Action:     "read",
+		Resource:   "bucket:main/collection:addons",
+	})
+	assert.False(t, decision.Allowed)
+}
+
+func TestResourceLevels(t *testing.T) {
+	assert.Equal(t, []string{"a/b/c", "a/b", "a"}, resourceLevels("a/b/c", "/"))
+	assert.Equal(t, []string{"a"}, resourceLevels("a", "/"))
+	assert.Equal(t, []string{"/a"}, resourceLevels("/a", "/"))
+	assert.Equal(t, []string{"a::b", "a"}, resourceLevels("a::b", "::"))
+}
+
+func TestHierarchyPermissions(t *testing.T) {
+	doorman := sampleHierarchyDoorman(t)
+
+	permissions := doorman.Permissions("https://hierarchy.yaml", &Request{
+		Principals: []string{"userid:foo"},
+		Resource:   "bucket:main/collection:addons/record:1",
+	})
+	assert.Equal(t, []Permission{
+		{Action: "read", Resource: "bucket:main/collection:addons/record:1"},
+	}, permissions)
+}
+
+func TestHierarchyAllowedPrincipals(t *testing.T) {
+	doorman := sampleHierarchyDoorman(t)
+
+	principals := doorman.AllowedPrincipals("https://hierarchy.yaml", &Request{
+		Action:   "read",
+		Resource: "bucket:main/collection:addons/record:1",
+	})
+	assert.Equal(t, Principals{"userid:foo"}, principals)
+}
@@ -3,38 +3,56 @@ package doorman
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ory/ladon"
-	manager "github.com/ory/ladon/manager/memory"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/mozilla/doorman/authn"
 )
 
-const maxInt int64 = 1<<63 - 1
-
 // LadonDoorman is the backend in charge of checking requests against policies.
 type LadonDoorman struct {
 	_auditLogger *auditLogger
 
-	services       map[string]ServiceConfig
-	ladons         map[string]*ladon.Ladon
-	authenticators map[string]authn.Authenticator
+	// shadowCounters count the divergences of shadow policies.
+	shadowCounters shadowCounters
+
+	// tuples holds the relationship tuples of the services.
+	tuples TupleStore
+	// rateLimits holds the counters of rate limit conditions.
+	rateLimits RateLimitStore
+
+	// newIndex builds the lookup structure of the policies of a service.
+	newIndex func(policies ladon.Policies) (policyIndex, error)
+
+	// mu serializes the changes of configuration.
+	mu sync.Mutex
+	// current holds the *snapshot that requests are evaluated against.
+	current atomic.Value
 }
 
 // NewDefaultLadon instantiates a new doorman.
 func NewDefaultLadon() *LadonDoorman {
 	w := &LadonDoorman{
-		services:       map[string]ServiceConfig{},
-		ladons:         map[string]*ladon.Ladon{},
-		authenticators: map[string]authn.Authenticator{},
+		_auditLogger: newAuditLogger(),
+		tuples:       NewMemoryTupleStore(),
+		rateLimits:   NewMemoryRateLimitStore(),
+		newIndex:     newScanIndex,
 	}
+	w.current.Store(newSnapshot())
 	return w
 }
 
+// snapshot returns the current configuration.
+func (doorman *LadonDoorman) snapshot() *snapshot {
+	return doorman.current.Load().(*snapshot)
+}
+
 func (doorman *LadonDoorman) ConfigSources() []string {
 	var l []string
-	for _, c := range doorman.services {
+	for _, c := range doorman.snapshot().services {
 		l = append(l, c.Source)
 	}
 	return l
@@ -43,7 +61,30 @@ func (doorman *LadonDoorman) ConfigSources() []string {
 // SetAuthenticator allows to manually set an authenticator instance associated to
 // a domain.
 func (doorman *LadonDoorman) SetAuthenticator(service string, a authn.Authenticator) {
-	doorman.authenticators[service] = a
+	doorman.mu.Lock()
+	defer doorman.mu.Unlock()
+
+	s := doorman.snapshot().copy()
+	s.authenticators[service] = a
+	doorman.current.Store(s)
+}
+
+// SetTupleStore replaces the storage of relationship tuples. It must be called
+// before the policies are loaded.
+func (doorman *LadonDoorman) SetTupleStore(store TupleStore) {
+	doorman.mu.Lock()
+	defer doorman.mu.Unlock()
+
+	doorman.tuples = store
+}
+
+// SetRateLimitStore replaces the storage of rate limits counters. It must be called
+// before the policies are loaded.
+func (doorman *LadonDoorman) SetRateLimitStore(store RateLimitStore) {
+	doorman.mu.Lock()
+	defer doorman.mu.Unlock()
+
+	doorman.rateLimits = store
 }
 
 func (doorman *LadonDoorman) auditLogger() *auditLogger {
@@ -55,13 +96,14 @@ func (doorman *LadonDoorman) auditLogger() *auditLogger {
 
 // LoadPolicies instantiates Ladon objects from doorman's.
 func (doorman *LadonDoorman) LoadPolicies(configs ServicesConfig) error {
+	doorman.mu.Lock()
+	defer doorman.mu.Unlock()
+
 	// First, load each configuration file.
-	newLadons := map[string]*ladon.Ladon{}
-	newAuthenticators := map[string]authn.Authenticator{}
-	newConfigs := map[string]ServiceConfig{}
+	s := newSnapshot()
 
 	for _, config := range configs {
-		_, exists := newConfigs[config.Service]
+		_, exists := s.services[config.Service]
 		if exists {
 			return fmt.Errorf("duplicated service %q (source %q)", config.Service, config.Source)
 		}
@@ -72,61 +114,206 @@ func (doorman *LadonDoorman) LoadPolicies(configs ServicesConfig) error {
 			if err != nil {
 				return err
 			}
-			newAuthenticators[config.Service] = v
+			s.authenticators[config.Service] = v
 		} else {
 			log.Warningf("No authentication enabled for %q.", config.Service)
 		}
 
-		newLadons[config.Service] = &ladon.Ladon{
-			Manager:     manager.NewMemoryManager(),
-			AuditLogger: doorman.auditLogger(),
+		switch config.CombiningAlgorithm {
+		case "":
+			config.CombiningAlgorithm = DenyOverrides
+		case DenyOverrides, PermitOverrides, FirstApplicable:
+		default:
+			return fmt.Errorf("unknown combining algorithm %q (source %q)", config.CombiningAlgorithm, config.Source)
 		}
+
+		tags, err := newTagIndex(config.Tags)
+		if err != nil {
+			return fmt.Errorf("%s (source %q)", err, config.Source)
+		}
+		s.tags[config.Service] = tags
+
+		roles, err := newRoleIndex(config.RoleBindings, config.ClientRoles)
+		if err != nil {
+			return fmt.Errorf("%s (source %q)", err, config.Source)
+		}
+		s.roles[config.Service] = roles
+
+		attributes, err := newAttributeProviders(config.AttributeProviders)
+		if err != nil {
+			return fmt.Errorf("%s (source %q)", err, config.Source)
+		}
+		s.attributes[config.Service] = attributes
+
+		graph, err := newRelationGraph(config.Service, doorman.tuples, config.Relations)
+		if err != nil {
+			return fmt.Errorf("%s (source %q)", err, config.Source)
+		}
+
+		ids := map[string]bool{}
+		// All policies, including the shadow ones, in the order of the configuration.
+		all := ladon.Policies{}
+		shadow := false
 		for _, pol := range config.Policies {
+			if ids[pol.ID] {
+				return fmt.Errorf("duplicated policy %q (source %q)", pol.ID, config.Source)
+			}
+			ids[pol.ID] = true
+
+			switch pol.Mode {
+			case "", EnforceMode, ShadowMode:
+			default:
+				return fmt.Errorf("unknown mode %q in policy %q (source %q)", pol.Mode, pol.ID, config.Source)
+			}
+
 			log.Debugf("Load policy %q: %s", pol.ID, pol.Description)
 
-			var conditions = ladon.Conditions{}
-			for field, cond := range pol.Conditions {
-				factory, found := ladon.ConditionFactories[cond.Type]
-				if !found {
-					return fmt.Errorf("unknown condition type %s", cond.Type)
+			conditions, err := buildConditions(pol.Conditions)
+			if err != nil {
+				return err
+			}
+			bindRelations(conditions, graph)
+
+			// Validity window of the policy, as conditions on server time.
+			if pol.NotBefore != "" {
+				date, err := parseDate(pol.NotBefore)
+				if err != nil {
+					return fmt.Errorf("%s in policy %q (source %q)", err, pol.ID, config.Source)
 				}
-				c := factory()
-				if len(cond.Options) > 0 {
-					// Leverage Ladon JSON unmarshall code to instantiate conditions.
-					str, _ := json.Marshal(cond.Options)
-					if err := json.Unmarshal(str, c); err != nil {
-						return err
-					}
+				conditions.AddCondition("_notBefore", &DateAfterCondition{Date: date})
+			}
+			if pol.NotAfter != "" {
+				date, err := parseDate(pol.NotAfter)
+				if err != nil {
+					return fmt.Errorf("%s in policy %q (source %q)", err, pol.ID, config.Source)
 				}
-				conditions.AddCondition(field, c)
+				conditions.AddCondition("_notAfter", &DateBeforeCondition{Date: date})
 			}
 
-			policy := &ladon.DefaultPolicy{
-				ID:          pol.ID,
-				Description: pol.Description,
-				Subjects:    pol.Principals,
-				Effect:      pol.Effect,
-				Resources:   pol.Resources,
-				Actions:     pol.Actions,
-				Conditions:  conditions,
+			// Exclusions, evaluated against all the principals of the request.
+			if len(pol.ExceptPrincipals) > 0 {
+				except, err := newExceptPrincipalsCondition(pol.ExceptPrincipals)
+				if err != nil {
+					return fmt.Errorf("%s in policy %q (source %q)", err, pol.ID, config.Source)
+				}
+				conditions.AddCondition("_exceptPrincipals", except)
 			}
-			err := newLadons[config.Service].Manager.Create(policy)
+
+			// Rate limits are counted when the policy grants a request.
+			limits, err := bindRateLimits(conditions, config.Service, pol.ID, doorman.rateLimits)
+			if err != nil {
+				return fmt.Errorf("%s in policy %q (source %q)", err, pol.ID, config.Source)
+			}
+			if len(limits) > 0 {
+				if s.limits[config.Service] == nil {
+					s.limits[config.Service] = map[string][]*RateLimitCondition{}
+				}
+				s.limits[config.Service][pol.ID] = limits
+			}
+
+			policy := &detailedPolicy{
+				DefaultPolicy: &ladon.DefaultPolicy{
+					ID:          pol.ID,
+					Description: pol.Description,
+					Subjects:    pol.Principals,
+					Effect:      pol.Effect,
+					Resources:   pol.Resources,
+					Actions:     pol.Actions,
+					Conditions:  conditions,
+				},
+				reason:      pol.Reason,
+				obligations: jsonableMap(pol.Obligations),
+				advice:      jsonableMap(pol.Advice),
+			}
+			all = append(all, policy)
+			if pol.Mode == ShadowMode {
+				shadow = true
+				continue
+			}
+			// Keep policies in the order of the configuration.
+			s.policies[config.Service] = append(s.policies[config.Service], policy)
+		}
+
+		index, err := doorman.newIndex(s.policies[config.Service])
+		if err != nil {
+			return err
+		}
+		s.indexes[config.Service] = index
+
+		if shadow {
+			index, err := doorman.newIndex(all)
 			if err != nil {
 				return err
 			}
+			s.shadows[config.Service] = index
 		}
-		newConfigs[config.Service] = config
+		s.services[config.Service] = config
 	}
 	// Only if everything went well, replace existing services with new ones.
-	doorman.services = newConfigs
-	doorman.ladons = newLadons
-	doorman.authenticators = newAuthenticators
+	doorman.current.Store(s)
 	return nil
 }
 
+// buildConditions instantiates Ladon conditions from doorman's.
+func buildConditions(conditions Conditions) (ladon.Conditions, error) {
+	result := ladon.Conditions{}
+	for field, cond := range conditions {
+		factory, found := ladon.ConditionFactories[cond.Type]
+		if !found {
+			return nil, fmt.Errorf("unknown condition type %s", cond.Type)
+		}
+		c := factory()
+		// Leverage Ladon JSON unmarshall code to instantiate conditions.
+		// (always, for conditions with mandatory options to fail)
+		str, err := json.Marshal(jsonable(cond.Options))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(str, c); err != nil {
+			return nil, err
+		}
+		result.AddCondition(field, c)
+	}
+	return result, nil
+}
+
+// jsonable converts the maps decoded from YAML (with interface{} keys) into
+// maps that can be encoded in JSON.
+func jsonable(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[fmt.Sprintf("%v", key)] = jsonable(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[key] = jsonable(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = jsonable(item)
+		}
+		return l
+	}
+	return value
+}
+
+// jsonableMap converts the nested maps decoded from YAML (see jsonable).
+func jsonableMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return jsonable(m).(map[string]interface{})
+}
+
 // Authenticator returns the authenticator for the specified service or nil.
 func (doorman *LadonDoorman) Authenticator(service string) (authn.Authenticator, error) {
-	v, ok := doorman.authenticators[service]
+	v, ok := doorman.snapshot().authenticators[service]
 	if !ok {
 		return nil, fmt.Errorf("unknown service %q", service)
 	}
@@ -134,43 +321,178 @@ func (doorman *LadonDoorman) Authenticator(service string) (authn.Authenticator,
 }
 
 // IsAllowed is responsible for deciding if subject can perform action on a resource with a context.
-func (doorman *LadonDoorman) IsAllowed(service string, request *Request) bool {
-	// Instantiate objects from the ladon API.
+func (doorman *LadonDoorman) IsAllowed(service string, request *Request) *Decision {
+	r := ladonRequest(request)
+
+	// Evaluate the whole request against the same configuration.
+	s := doorman.snapshot()
+
+	if _, ok := s.services[service]; !ok {
+		decision := &Decision{
+			Policies: []string{},
+			Reason:   ReasonUnknownService,
+		}
+		// Explicitly log denied request using audit logger.
+		doorman.auditLogger().logRequest(service, request, r, decision)
+		return decision
+	}
+
+	// Enrich the context with the attributes of the resource.
+	if err := s.attributes[service].enrich(request, r.Context); err != nil {
+		log.Warningf("Could not obtain attributes: %s", err)
+		decision := &Decision{
+			Policies: []string{},
+			Reason:   ReasonMissingAttributes,
+		}
+		doorman.auditLogger().logRequest(service, request, r, decision)
+		return decision
+	}
+
+	// Shadow policies are evaluated before the allowed request is counted in the
+	// rate limits, like the enforced ones.
+	shadow := s.decideShadow(service, request.Principals, r)
+
+	// Rate limits are counted once the request is allowed.
+	decision := s.allow(service, request.Principals, r)
+
+	// Shadow policies never change the decision, divergences are only logged.
+	if shadow != nil && shadow.Allowed != decision.Allowed {
+		doorman.shadowCounters.add(service, shadow)
+		doorman.auditLogger().logShadow(service, request, r, decision, shadow)
+	}
+
+	doorman.auditLogger().logRequest(service, request, r, decision)
+	return decision
+}
+
+// UpdateTuples removes and adds relationship tuples of the service atomically.
+func (doorman *LadonDoorman) UpdateTuples(service string, deletes []Tuple, writes []Tuple) error {
+	if _, ok := doorman.snapshot().services[service]; !ok {
+		return fmt.Errorf("unknown service %q", service)
+	}
+	return doorman.tuples.Update(service, deletes, writes)
+}
+
+// ladonRequest instantiates the request object of the Ladon API.
+func ladonRequest(request *Request) *ladon.Request {
 	context := ladon.Context{}
 	for key, value := range request.Context {
 		context[key] = value
 	}
-
-	r := &ladon.Request{
+	return &ladon.Request{
 		Resource: request.Resource,
 		Action:   request.Action,
 		Context:  context,
 	}
+}
 
-	l, ok := doorman.ladons[service]
-	if !ok {
-		// Explicitly log denied request using audit logger.
-		doorman.auditLogger().logRequest(false, r, ladon.Policies{})
-		return false
+// scanIndex evaluates every policy of the service using the Ladon matcher.
+type scanIndex ladon.Policies
+
+func newScanIndex(policies ladon.Policies) (policyIndex, error) {
+	return scanIndex(policies), nil
+}
+
+func (idx scanIndex) match(principals Principals, r *ladon.Request) []match {
+	return matchPolicies(ladon.Policies(idx), principals, r)
+}
+
+// matchPolicies returns the policies that apply to the request, in the order of the
+// configuration. A policy applies if it matches the action, the resource and one
+// of the principals for which its conditions are fulfilled.
+func matchPolicies(policies ladon.Policies, principals Principals, r *ladon.Request) []match {
+	matches := []match{}
+	for _, policy := range policies {
+		principal, err := matchPolicy(policy, principals, r)
+		if err != nil {
+			log.Warningf("Could not match policy %q: %s", policy.GetID(), err)
+			continue
+		}
+		if principal != "" {
+			matches = append(matches, match{policy: policy, principal: principal})
+		}
 	}
+	return matches
+}
 
-	// For each principal, use it as the subject and query ladon backend.
-	for _, principal := range request.Principals {
+// matchPolicy returns the first principal for which the policy applies, or an empty string.
+func matchPolicy(policy ladon.Policy, principals Principals, r *ladon.Request) (string, error) {
+	matcher := ladon.DefaultMatcher
+	if ok, err := matcher.Matches(policy, policy.GetActions(), r.Action); err != nil || !ok {
+		return "", err
+	}
+	if ok, err := matcher.Matches(policy, policy.GetResources(), r.Resource); err != nil || !ok {
+		return "", err
+	}
+	return matchPrincipals(policy, principals, r)
+}
+
+// matchPrincipals returns the first principal for which the policy subjects and
+// conditions are fulfilled, or an empty string.
+func matchPrincipals(policy ladon.Policy, principals Principals, r *ladon.Request) (string, error) {
+	matcher := ladon.DefaultMatcher
+	for _, principal := range principals {
+		ok, err := matcher.Matches(policy, policy.GetSubjects(), principal)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		// Some conditions depend on the subject (eg. MatchPrincipalsCondition).
 		r.Subject = principal
-		if err := l.IsAllowed(r); err == nil {
-			return true
+		if fulfills(policy.GetConditions(), &evaluation{request: r, principals: principals}) {
+			return principal, nil
 		}
 	}
-	return false
+	return "", nil
+}
+
+// evaluation is a request being evaluated against the conditions of a policy.
+type evaluation struct {
+	request *ladon.Request
+	// principals are all the principals of the request (the subject being one of them).
+	principals Principals
+}
+
+// requestCondition is a condition that needs the whole request (eg. all principals),
+// and not only the context field value and the subject.
+type requestCondition interface {
+	ladon.Condition
+	fulfillsRequest(value interface{}, e *evaluation) bool
+}
+
+// fulfills returns true if every condition is fulfilled by the request context.
+func fulfills(conditions ladon.Conditions, e *evaluation) bool {
+	for field, condition := range conditions {
+		if !fulfillsCondition(condition, e.request.Context[field], e) {
+			return false
+		}
+	}
+	return true
+}
+
+// fulfillsCondition returns true if the condition is fulfilled by the value.
+func fulfillsCondition(condition ladon.Condition, value interface{}, e *evaluation) bool {
+	if c, ok := condition.(requestCondition); ok {
+		return c.fulfillsRequest(value, e)
+	}
+	return condition.Fulfills(value, e.request)
+}
+
+// subjectEvaluation is used when conditions are evaluated through the Ladon API,
+// where the subject is the only known principal.
+func subjectEvaluation(r *ladon.Request) *evaluation {
+	return &evaluation{request: r, principals: Principals{r.Subject}}
 }
 
 // ExpandPrincipals will match the tags defined in the configuration for this service
 // against each of the specified principals.
 func (doorman *LadonDoorman) ExpandPrincipals(service string, principals Principals) Principals {
-	c, ok := doorman.services[service]
+	tags, ok := doorman.snapshot().tags[service]
 	if !ok {
 		return principals
 	}
 
-	return append(principals, c.GetTags(principals)...)
+	return append(principals, tags.expand(principals)...)
 }
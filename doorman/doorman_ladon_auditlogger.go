@@ -2,82 +2,214 @@ package doorman
 
 import (
 	"os"
+	"sync"
+	"time"
 
 	"github.com/ory/ladon"
 	"github.com/sirupsen/logrus"
-	"go.mozilla.org/mozlogrus"
 )
 
+// Audit entry types.
+const (
+	// AuditAuthorization is the type of the entries of authorization decisions.
+	AuditAuthorization = "request.authorization"
+	// AuditShadow is the type of the entries of shadow policies divergences.
+	AuditShadow = "request.authorization.shadow"
+)
+
+// AuditEntry is a decision to be recorded by the audit sinks.
+type AuditEntry struct {
+	Time   time.Time
+	Type   string
+	Fields map[string]interface{}
+}
+
+// AuditSink records the audit entries (eg. in a log file).
+type AuditSink interface {
+	// Write records the entry.
+	Write(entry *AuditEntry) error
+	// Close flushes the pending entries and releases the sink.
+	Close() error
+}
+
 type auditLogger struct {
-	logger *logrus.Logger
+	mu       sync.RWMutex
+	sinks    []AuditSink
+	chain    *AuditChain
+	redactor *Redactor
+	// stopCheckpoints stops the timer of the chain checkpoints.
+	stopCheckpoints chan struct{}
 }
 
 func newAuditLogger() *auditLogger {
-	authzLog := &logrus.Logger{
-		Out:       os.Stdout,
-		Formatter: &mozlogrus.MozLogFormatter{LoggerName: "doorman", Type: "request.authorization"},
-		Hooks:     make(logrus.LevelHooks),
-		Level:     logrus.InfoLevel,
+	return &auditLogger{
+		sinks: []AuditSink{NewMozLogAuditSink(os.Stdout)},
 	}
-	return &auditLogger{logger: authzLog}
 }
 
-func (a *auditLogger) logRequest(allowed bool, r *ladon.Request, policies ladon.Policies) {
-	policiesNames := []string{}
-	for _, p := range policies {
-		policiesNames = append(policiesNames, p.GetID())
-	}
+// setSinks replaces the sinks, and closes the previous ones.
+func (a *auditLogger) setSinks(sinks []AuditSink) {
+	a.mu.Lock()
+	previous := a.sinks
+	a.sinks = sinks
+	a.mu.Unlock()
 
-	// Remove custom values out of context for nicer logging (were set in handler)
-	var principals Principals
-	var service string
-	var remoteIP string
-	context := map[string]interface{}{}
-	for k, v := range r.Context {
-		if k == "_principals" {
-			principals = v.(Principals)
-		} else if k == "_service" {
-			service = v.(string)
-		} else if k == "remoteIP" {
-			remoteIP = v.(string)
-		} else {
-			context[k] = v
+	for _, sink := range previous {
+		if err := sink.Close(); err != nil {
+			logrus.Warningf("Could not close audit sink: %s", err)
 		}
 	}
+}
 
-	a.logger.WithFields(
-		logrus.Fields{
-			"allowed":    allowed,
-			"principals": principals,
-			"service":    service,
-			"remoteIP":   remoteIP,
-			"policies":   policiesNames,
-			"action":     r.Action,
-			"resource":   r.Resource,
-			"context":    context,
-		},
-	).Info("")
+// setChain enables (or disables if nil) the chaining of entries.
+func (a *auditLogger) setChain(chain *AuditChain) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopCheckpoints != nil {
+		close(a.stopCheckpoints)
+		a.stopCheckpoints = nil
+	}
+	a.chain = chain
+	if chain != nil && chain.interval > 0 {
+		a.stopCheckpoints = make(chan struct{})
+		go a.runCheckpoints(chain, a.stopCheckpoints)
+	}
 }
 
-// LogRejectedAccessRequest is called by Ladon when a request is denied.
-func (a *auditLogger) LogRejectedAccessRequest(request *ladon.Request, pool ladon.Policies, deciders ladon.Policies) {
-	// Since we iterate on principals to test individual subjects, when a request is denied
-	// we want to log the last one only, ie. when r.subject == last(principals)
-	principals := request.Context["_principals"].(Principals)
-	if request.Subject != principals[len(principals)-1] {
+// runCheckpoints signs the chain periodically, so that the last entries are
+// covered by a checkpoint even if no other entry is written.
+func (a *auditLogger) runCheckpoints(chain *AuditChain, stop chan struct{}) {
+	ticker := time.NewTicker(chain.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			a.checkpoint(chain)
+		}
+	}
+}
+
+// checkpoint writes a checkpoint of the chain if entries were written since the last one.
+func (a *auditLogger) checkpoint(chain *AuditChain) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.chain != chain {
 		return
 	}
 
-	if len(deciders) > 0 {
-		// Explicitly denied by the last one.
-		a.logRequest(false, request, deciders[len(deciders)-1:])
-	} else {
-		// No matching policy.
-		a.logRequest(false, request, deciders)
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	entries, err := chain.flush(now())
+	if err != nil {
+		logrus.Errorf("Could not sign audit chain: %s", err)
+		return
 	}
+	a.writeEntries(entries)
 }
 
-// LogGrantedAccessRequest is called by Ladon when a request is granted.
-func (a *auditLogger) LogGrantedAccessRequest(request *ladon.Request, pool ladon.Policies, deciders ladon.Policies) {
-	a.logRequest(true, request, deciders)
+// setRedactor sets (or removes if nil) the redaction rules of entries.
+func (a *auditLogger) setRedactor(r *Redactor) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.redactor = r
+}
+
+func (a *auditLogger) write(entry *AuditEntry) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	// Personal data is redacted before being chained and written.
+	a.redact(entry)
+
+	entries := []*AuditEntry{entry}
+	if a.chain != nil {
+		// Entries are written in the order of the chain.
+		a.chain.mu.Lock()
+		defer a.chain.mu.Unlock()
+		linked, err := a.chain.link(entry)
+		if err != nil {
+			// The entries already chained are written, to keep the chain intact.
+			logrus.Errorf("Could not chain audit entry: %s", err)
+		}
+		entries = linked
+	}
+	a.writeEntries(entries)
+}
+
+// writeEntries writes the entries to every sink. The lock must be held.
+func (a *auditLogger) writeEntries(entries []*AuditEntry) {
+	for _, e := range entries {
+		for _, sink := range a.sinks {
+			if err := sink.Write(e); err != nil {
+				logrus.Errorf("Could not write audit entry: %s", err)
+			}
+		}
+	}
+}
+
+// redact applies the redaction rules to the principals, subject and context fields.
+// The lock must be held.
+func (a *auditLogger) redact(entry *AuditEntry) {
+	if a.redactor == nil {
+		return
+	}
+	if principals, ok := entry.Fields["principals"].(Principals); ok {
+		entry.Fields["principals"] = a.redactor.Principals(principals)
+	}
+	if subject, ok := entry.Fields["subject"].(string); ok {
+		entry.Fields["subject"] = a.redactor.Subject(subject)
+	}
+	if context, ok := entry.Fields["context"].(map[string]interface{}); ok {
+		entry.Fields["context"] = a.redactor.Context(context)
+	}
+}
+
+func (a *auditLogger) logRequest(service string, request *Request, r *ladon.Request, decision *Decision) {
+	a.write(&AuditEntry{
+		Time:   now(),
+		Type:   AuditAuthorization,
+		Fields: requestFields(service, request, r, decision),
+	})
+}
+
+// logShadow logs a request for which the shadow decision differs from the enforced one.
+func (a *auditLogger) logShadow(service string, request *Request, r *ladon.Request, decision *Decision, shadow *Decision) {
+	fields := requestFields(service, request, r, decision)
+	fields["shadowAllowed"] = shadow.Allowed
+	fields["shadowPolicies"] = shadow.Policies
+	fields["shadowReason"] = shadow.Reason
+	a.write(&AuditEntry{
+		Time:   now(),
+		Type:   AuditShadow,
+		Fields: fields,
+	})
+}
+
+// requestFields returns the logged fields of the decision. The service, principals
+// and metadata are taken from the request, the context is the evaluated one (ie.
+// with the attributes of the resource). The remote address forced in the context
+// by the API is only logged once, as remoteIP.
+func requestFields(service string, request *Request, r *ladon.Request, decision *Decision) logrus.Fields {
+	context := map[string]interface{}{}
+	for k, v := range r.Context {
+		if k == "remoteIP" {
+			continue
+		}
+		context[k] = v
+	}
+	return logrus.Fields{
+		"allowed":    decision.Allowed,
+		"principals": request.Principals,
+		"service":    service,
+		"remoteIP":   request.Metadata.RemoteAddr,
+		"requestID":  request.Metadata.RequestID,
+		"subject":    request.Metadata.Subject,
+		"policies":   decision.Policies,
+		"reason":     decision.Reason,
+		"action":     r.Action,
+		"resource":   r.Resource,
+		"context":    context,
+	}
 }
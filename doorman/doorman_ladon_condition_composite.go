@@ -0,0 +1,124 @@
+package doorman
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ory/ladon"
+)
+
+// compositeOptions are the options of composite conditions: the nested conditions
+// on context fields.
+//
+//	{"conditions": {"env": {"type": "StringEqualCondition", "options": {"equals": "stage"}}}}
+type compositeOptions struct {
+	Conditions Conditions `json:"conditions"`
+}
+
+// unmarshalComposite instantiates the nested conditions of a composite condition.
+func unmarshalComposite(name string, data []byte) (ladon.Conditions, error) {
+	var options compositeOptions
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, err
+	}
+	if len(options.Conditions) == 0 {
+		return nil, fmt.Errorf("missing conditions in %s", name)
+	}
+	return buildConditions(options.Conditions)
+}
+
+// AllOfCondition is a condition which is fulfilled if all its nested conditions are fulfilled.
+type AllOfCondition struct {
+	Conditions ladon.Conditions
+}
+
+// UnmarshalJSON instantiates the nested conditions.
+func (c *AllOfCondition) UnmarshalJSON(data []byte) (err error) {
+	c.Conditions, err = unmarshalComposite(c.GetName(), data)
+	return err
+}
+
+// Fulfills returns true if every nested condition is fulfilled by its context field.
+// The value is ignored.
+func (c *AllOfCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	return c.fulfillsRequest(value, subjectEvaluation(r))
+}
+
+func (c *AllOfCondition) fulfillsRequest(value interface{}, e *evaluation) bool {
+	return fulfills(c.Conditions, e)
+}
+
+// GetName returns the condition's name.
+func (c *AllOfCondition) GetName() string {
+	return "AllOfCondition"
+}
+
+// AnyOfCondition is a condition which is fulfilled if one of its nested conditions is fulfilled.
+type AnyOfCondition struct {
+	Conditions ladon.Conditions
+}
+
+// UnmarshalJSON instantiates the nested conditions.
+func (c *AnyOfCondition) UnmarshalJSON(data []byte) (err error) {
+	c.Conditions, err = unmarshalComposite(c.GetName(), data)
+	return err
+}
+
+// Fulfills returns true if one of the nested conditions is fulfilled by its context field.
+// The value is ignored.
+func (c *AnyOfCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	return c.fulfillsRequest(value, subjectEvaluation(r))
+}
+
+func (c *AnyOfCondition) fulfillsRequest(value interface{}, e *evaluation) bool {
+	for field, condition := range c.Conditions {
+		if fulfillsCondition(condition, e.request.Context[field], e) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetName returns the condition's name.
+func (c *AnyOfCondition) GetName() string {
+	return "AnyOfCondition"
+}
+
+// NotCondition is a condition which is fulfilled if its nested conditions are not
+// (all) fulfilled.
+type NotCondition struct {
+	Conditions ladon.Conditions
+}
+
+// UnmarshalJSON instantiates the nested conditions.
+func (c *NotCondition) UnmarshalJSON(data []byte) (err error) {
+	c.Conditions, err = unmarshalComposite(c.GetName(), data)
+	return err
+}
+
+// Fulfills returns false if every nested condition is fulfilled by its context field.
+// The value is ignored.
+func (c *NotCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	return c.fulfillsRequest(value, subjectEvaluation(r))
+}
+
+func (c *NotCondition) fulfillsRequest(value interface{}, e *evaluation) bool {
+	return !fulfills(c.Conditions, e)
+}
+
+// GetName returns the condition's name.
+func (c *NotCondition) GetName() string {
+	return "NotCondition"
+}
+
+func init() {
+	ladon.ConditionFactories[new(AllOfCondition).GetName()] = func() ladon.Condition {
+		return new(AllOfCondition)
+	}
+	ladon.ConditionFactories[new(AnyOfCondition).GetName()] = func() ladon.Condition {
+		return new(AnyOfCondition)
+	}
+	ladon.ConditionFactories[new(NotCondition).GetName()] = func() ladon.Condition {
+		return new(NotCondition)
+	}
+}
@@ -0,0 +1,96 @@
+package doorman
+
+import (
+	"testing"
+
+	"github.com/ory/ladon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompositeConditions(t *testing.T) {
+	// Options as decoded from YAML.
+	conditions, err := buildConditions(Conditions{
+		"stageOrVPN": Condition{
+			Type: "AnyOfCondition",
+			Options: map[string]interface{}{
+				"conditions": map[interface{}]interface{}{
+					"env": map[interface{}]interface{}{
+						"type": "StringEqualCondition",
+						"options": map[interface{}]interface{}{
+							"equals": "stage",
+						},
+					},
+					"remoteIP": map[interface{}]interface{}{
+						"type": "CIDRCondition",
+						"options": map[interface{}]interface{}{
+							"cidr": "10.0.0.0/8",
+						},
+					},
+				},
+			},
+		},
+		"notMars": Condition{
+			Type: "NotCondition",
+			Options: map[string]interface{}{
+				"conditions": map[string]interface{}{
+					"planet": map[string]interface{}{
+						"type": "AllOfCondition",
+						"options": map[string]interface{}{
+							"conditions": map[string]interface{}{
+								"planet": map[string]interface{}{
+									"type": "StringEqualCondition",
+									"options": map[string]interface{}{
+										"equals": "mars",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	for _, test := range []struct {
+		context  ladon.Context
+		expected bool
+	}{
+		{ladon.Context{"env": "stage"}, true},
+		{ladon.Context{"env": "prod", "remoteIP": "10.1.2.3"}, true},
+		{ladon.Context{"env": "prod", "remoteIP": "192.168.1.1"}, false},
+		{ladon.Context{"env": "stage", "planet": "mars"}, false},
+		{ladon.Context{"env": "stage", "planet": "earth"}, true},
+	} {
+		r := &ladon.Request{Context: test.context}
+		assert.Equal(t, test.expected, fulfills(conditions, subjectEvaluation(r)), test.context)
+	}
+}
+
+func TestBadCompositeConditions(t *testing.T) {
+	// Unknown nested type.
+	_, err := buildConditions(Conditions{
+		"any": Condition{
+			Type: "AnyOfCondition",
+			Options: map[string]interface{}{
+				"conditions": map[string]interface{}{
+					"env": map[string]interface{}{
+						"type": "healthy",
+					},
+				},
+			},
+		},
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "unknown condition type healthy", err.Error())
+
+	// Missing nested conditions.
+	_, err = buildConditions(Conditions{
+		"not": Condition{
+			Type: "NotCondition",
+		},
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "missing conditions in NotCondition", err.Error())
+}
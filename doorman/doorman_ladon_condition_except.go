@@ -0,0 +1,73 @@
+package doorman
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ory/ladon"
+)
+
+// ExceptPrincipalsCondition is a condition which is fulfilled if none of the
+// principals of the request matches the specified ones (regular expressions
+// are supported).
+type ExceptPrincipalsCondition struct {
+	Principals []string `json:"principals"`
+
+	patterns *patterns
+}
+
+// newExceptPrincipalsCondition compiles the principals of the condition.
+func newExceptPrincipalsCondition(principals []string) (*ExceptPrincipalsCondition, error) {
+	c := &ExceptPrincipalsCondition{Principals: principals}
+	p, err := newPatterns(principals, '<', '>', c.GetName())
+	if err != nil {
+		return nil, err
+	}
+	c.patterns = p
+	return c, nil
+}
+
+// UnmarshalJSON compiles the principals of the condition options.
+func (c *ExceptPrincipalsCondition) UnmarshalJSON(data []byte) error {
+	var options struct {
+		Principals []string `json:"principals"`
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+	if len(options.Principals) == 0 {
+		return fmt.Errorf("missing principals in %s", c.GetName())
+	}
+	compiled, err := newExceptPrincipalsCondition(options.Principals)
+	if err != nil {
+		return err
+	}
+	*c = *compiled
+	return nil
+}
+
+// Fulfills returns true if the request's subject is not excluded.
+func (c *ExceptPrincipalsCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	return c.fulfillsRequest(value, subjectEvaluation(r))
+}
+
+// fulfillsRequest returns true if none of the principals is excluded. The value is ignored.
+func (c *ExceptPrincipalsCondition) fulfillsRequest(value interface{}, e *evaluation) bool {
+	for _, principal := range e.principals {
+		if c.patterns.matches(principal) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetName returns the condition's name.
+func (c *ExceptPrincipalsCondition) GetName() string {
+	return "ExceptPrincipalsCondition"
+}
+
+func init() {
+	ladon.ConditionFactories[new(ExceptPrincipalsCondition).GetName()] = func() ladon.Condition {
+		return new(ExceptPrincipalsCondition)
+	}
+}
@@ -0,0 +1,149 @@
+package doorman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exceptDoorman(t *testing.T) *LadonDoorman {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Tags: Tags{
+				"bots": Principals{"userid:bot", "userid:<ci-.*>"},
+			},
+			Policies: Policies{
+				Policy{
+					ID:               "employees-except-contractors",
+					Principals:       Principals{"group:employees"},
+					ExceptPrincipals: []string{"group:contractors"},
+					Actions:          []string{"read"},
+					Resources:        []string{"<.*>"},
+					Effect:           "allow",
+				},
+				Policy{
+					ID:               "everyone-but-bots",
+					Principals:       Principals{"<.*>"},
+					ExceptPrincipals: []string{"tag:bots", "userid:<test-.*>"},
+					Actions:          []string{"comment"},
+					Resources:        []string{"<.*>"},
+					Effect:           "allow",
+				},
+				Policy{
+					ID:         "admins-except-interns",
+					Principals: Principals{"group:admins"},
+					Actions:    []string{"delete"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"interns": Condition{
+							Type: "ExceptPrincipalsCondition",
+							Options: map[string]interface{}{
+								"principals": []string{"group:interns"},
+							},
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+	return d
+}
+
+func TestExceptPrincipals(t *testing.T) {
+	d := exceptDoorman(t)
+
+	var cases = []struct {
+		principals Principals
+		action     string
+		allowed    bool
+	}{
+		{Principals{"userid:alice", "group:employees"}, "read", true},
+		// Excluded by another principal than the matching one.
+		{Principals{"userid:bob", "group:employees", "group:contractors"}, "read", false},
+		{Principals{"userid:alice"}, "comment", true},
+		{Principals{"userid:test-42"}, "comment", false},
+		// Excluded by tag.
+		{Principals{"userid:bot"}, "comment", false},
+		{Principals{"userid:ci-1"}, "comment", false},
+		// As condition.
+		{Principals{"group:admins"}, "delete", true},
+		{Principals{"group:admins", "group:interns"}, "delete", false},
+	}
+	for _, c := range cases {
+		principals := d.ExpandPrincipals("a", c.principals)
+		decision := d.IsAllowed("a", &Request{
+			Principals: principals,
+			Action:     c.action,
+			Resource:   "record",
+		})
+		assert.Equal(t, c.allowed, decision.Allowed, "%v %s", c.principals, c.action)
+	}
+
+	// Excluded principals themselves are not listed in reverse lookups.
+	principals := d.AllowedPrincipals("a", &Request{
+		Action:   "comment",
+		Resource: "record",
+	})
+	assert.Equal(t, Principals{"<.*>"}, principals)
+}
+
+func TestBadExceptPrincipals(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{ID: "1", ExceptPrincipals: []string{"userid:<[a-z>"}},
+			},
+		},
+	})
+	assert.Contains(t, err.Error(), "invalid pattern \"userid:<[a-z>\" in ExceptPrincipalsCondition")
+	assert.Contains(t, err.Error(), "in policy \"1\"")
+
+	err = d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID: "1",
+					Conditions: Conditions{
+						"interns": Condition{Type: "ExceptPrincipalsCondition"},
+					},
+				},
+			},
+		},
+	})
+	assert.Contains(t, err.Error(), "missing principals in ExceptPrincipalsCondition")
+}
+
+func TestExceptPrincipalsAllowedPrincipals(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Tags: Tags{
+				"staff": Principals{"userid:alice", "userid:bob"},
+			},
+			Policies: Policies{
+				Policy{
+					ID:               "staff-but-bob",
+					Principals:       Principals{"tag:staff"},
+					ExceptPrincipals: []string{"userid:bob"},
+					Actions:          []string{"read"},
+					Resources:        []string{"<.*>"},
+					Effect:           "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	request := &Request{Action: "read", Resource: "a"}
+	assert.Equal(t, Principals{"tag:staff", "userid:alice"}, d.AllowedPrincipals("a", request))
+	assert.False(t, d.IsAllowed("a", &Request{Principals: Principals{"userid:bob", "tag:staff"}, Action: "read", Resource: "a"}).Allowed)
+}
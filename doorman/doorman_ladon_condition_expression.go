@@ -0,0 +1,112 @@
+package doorman
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/ory/ladon"
+	log "github.com/sirupsen/logrus"
+)
+
+// expressionEnv declares the variables available in expressions.
+var expressionEnv *cel.Env
+
+// ExpressionCondition is a condition which is fulfilled if the expression
+// (https://github.com/google/cel-spec) evaluates to true. For example:
+//
+//	context.owner in principals && context.size < 100
+//
+// The variables are principals, subject, action, resource and context.
+type ExpressionCondition struct {
+	Expression string
+	program    cel.Program
+	// principals is true if the expression refers to the principals or the subject.
+	principals bool
+}
+
+// UnmarshalJSON compiles the expression of the condition options.
+func (c *ExpressionCondition) UnmarshalJSON(data []byte) error {
+	var options struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+	if options.Expression == "" {
+		return fmt.Errorf("missing expression in %s", c.GetName())
+	}
+
+	ast, issues := expressionEnv.Compile(options.Expression)
+	if issues != nil && issues.Err() != nil {
+		return fmt.Errorf("invalid expression %q: %s", options.Expression, issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return fmt.Errorf("invalid expression %q: returns %s instead of bool", options.Expression, ast.OutputType())
+	}
+	program, err := expressionEnv.Program(ast)
+	if err != nil {
+		return fmt.Errorf("invalid expression %q: %s", options.Expression, err)
+	}
+	checked, err := cel.AstToCheckedExpr(ast)
+	if err != nil {
+		return fmt.Errorf("invalid expression %q: %s", options.Expression, err)
+	}
+	c.Expression = options.Expression
+	c.program = program
+	c.principals = false
+	for _, reference := range checked.GetReferenceMap() {
+		if name := reference.GetName(); name == "principals" || name == "subject" {
+			c.principals = true
+		}
+	}
+	return nil
+}
+
+// Fulfills returns true if the expression evaluates to true. The value is ignored.
+func (c *ExpressionCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	return c.fulfillsRequest(value, subjectEvaluation(r))
+}
+
+func (c *ExpressionCondition) fulfillsRequest(value interface{}, e *evaluation) bool {
+	if c.program == nil {
+		return false
+	}
+	out, _, err := c.program.Eval(map[string]interface{}{
+		"principals": []string(e.principals),
+		"subject":    e.request.Subject,
+		"action":     e.request.Action,
+		"resource":   e.request.Resource,
+		"context":    map[string]interface{}(e.request.Context),
+	})
+	if err != nil {
+		// Missing context fields or values of unexpected types.
+		log.Debugf("Could not evaluate expression %q: %s", c.Expression, err)
+		return false
+	}
+	result, ok := out.Value().(bool)
+	return ok && result
+}
+
+// GetName returns the condition's name.
+func (c *ExpressionCondition) GetName() string {
+	return "ExpressionCondition"
+}
+
+func init() {
+	env, err := cel.NewEnv(
+		cel.Variable("principals", cel.ListType(cel.StringType)),
+		cel.Variable("subject", cel.StringType),
+		cel.Variable("action", cel.StringType),
+		cel.Variable("resource", cel.StringType),
+		cel.Variable("context", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		panic(err)
+	}
+	expressionEnv = env
+
+	ladon.ConditionFactories[new(ExpressionCondition).GetName()] = func() ladon.Condition {
+		return new(ExpressionCondition)
+	}
+}
@@ -0,0 +1,105 @@
+package doorman
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpressionCondition(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "1",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"upload"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"small": Condition{
+							Type: "ExpressionCondition",
+							Options: map[string]interface{}{
+								"expression": "context.owner in principals && context.size < 100",
+							},
+						},
+					},
+					Effect: "allow",
+				},
+				Policy{
+					ID:         "2",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"read"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"public": Condition{
+							Type: "ExpressionCondition",
+							Options: map[string]interface{}{
+								"expression": "resource.startsWith('public/') || subject == 'userid:admin'",
+							},
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	for _, test := range []struct {
+		request  *Request
+		expected bool
+	}{
+		// Owner is one of the principals, not necessarily the matching one.
+		{&Request{Principals: Principals{"userid:bob", "group:admins"}, Action: "upload", Resource: "file", Context: Context{"owner": "group:admins", "size": 42}}, true},
+		// Numbers decoded from JSON.
+		{&Request{Principals: Principals{"userid:bob"}, Action: "upload", Resource: "file", Context: Context{"owner": "userid:bob", "size": 42.0}}, true},
+		{&Request{Principals: Principals{"userid:bob"}, Action: "upload", Resource: "file", Context: Context{"owner": "userid:bob", "size": 142}}, false},
+		{&Request{Principals: Principals{"userid:bob"}, Action: "upload", Resource: "file", Context: Context{"owner": "userid:alice", "size": 1}}, false},
+		// Missing context field.
+		{&Request{Principals: Principals{"userid:bob"}, Action: "upload", Resource: "file", Context: Context{"size": 1}}, false},
+		// Values of unexpected type.
+		{&Request{Principals: Principals{"userid:bob"}, Action: "upload", Resource: "file", Context: Context{"owner": "userid:bob", "size": "1"}}, false},
+		// Request fields.
+		{&Request{Principals: Principals{"userid:bob"}, Action: "read", Resource: "public/index"}, true},
+		{&Request{Principals: Principals{"userid:bob"}, Action: "read", Resource: "private/index"}, false},
+		{&Request{Principals: Principals{"userid:bob", "userid:admin"}, Action: "read", Resource: "private/index"}, true},
+	} {
+		assert.Equal(t, test.expected, d.IsAllowed("a", test.request).Allowed, test.request)
+	}
+}
+
+func TestBadExpressionCondition(t *testing.T) {
+	for _, test := range []struct {
+		options  string
+		expected string
+	}{
+		{`{}`, "missing expression in ExpressionCondition"},
+		{`{"expression": "context.owner in"}`, "invalid expression \"context.owner in\": ERROR"},
+		{`{"expression": "unknown == 1"}`, "undeclared reference to 'unknown'"},
+		{`{"expression": "action + 'a'"}`, "returns string instead of bool"},
+		{`{"expression": "size(principals) > 'a'"}`, "found no matching overload"},
+	} {
+		err := json.Unmarshal([]byte(test.options), &ExpressionCondition{})
+		require.NotNil(t, err, test.options)
+		assert.Contains(t, err.Error(), test.expected)
+	}
+}
+
+func TestExpressionConditionPrincipals(t *testing.T) {
+	for expression, expected := range map[string]bool{
+		`context.env == "prod"`:                false,
+		`"userid:alice" in principals`:         true,
+		`subject.startsWith("userid:")`:        true,
+		`resource == "a" && context.size < 10`: false,
+	} {
+		options, _ := json.Marshal(map[string]string{"expression": expression})
+		c := &ExpressionCondition{}
+		err := json.Unmarshal(options, c)
+		require.Nil(t, err)
+		assert.Equal(t, expected, dependsOnPrincipals(c), expression)
+	}
+}
@@ -0,0 +1,285 @@
+package doorman
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ory/ladon"
+	log "github.com/sirupsen/logrus"
+)
+
+// rateLimitPurgeInterval is the minimum duration between two purges of the idle
+// keys of the memory store.
+const rateLimitPurgeInterval = time.Minute
+
+// RateLimitStore keeps the sliding window counters of rate limit conditions.
+// It can be replaced by a shared backend when several instances are running.
+type RateLimitStore interface {
+	// Count returns the number of hits of the key since the specified time.
+	Count(key string, since time.Time) (int, error)
+	// Reserve records a hit of the key if it has less than limit hits in the window,
+	// as a single atomic operation. It returns false if the limit is reached. Hits
+	// older than the window can be dropped.
+	Reserve(key string, at time.Time, window time.Duration, limit int) (bool, error)
+	// Cancel removes a hit recorded with Reserve.
+	Cancel(key string, at time.Time) error
+}
+
+// MemoryRateLimitStore keeps the hits in memory.
+type MemoryRateLimitStore struct {
+	mu     sync.Mutex
+	hits   map[string]*rateLimitHits
+	purged time.Time
+}
+
+type rateLimitHits struct {
+	times  []time.Time
+	window time.Duration
+}
+
+// NewMemoryRateLimitStore instantiates an empty store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		hits: map[string]*rateLimitHits{},
+	}
+}
+
+// Count returns the number of hits of the key since the specified time.
+func (m *MemoryRateLimitStore) Count(key string, since time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	if hits, ok := m.hits[key]; ok {
+		for _, at := range hits.times {
+			if at.After(since) {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// Reserve records a hit of the key if it is under the limit, and drops the ones
+// that are out of the window. The keys without hits in their window are purged
+// from time to time.
+func (m *MemoryRateLimitStore) Reserve(key string, at time.Time, window time.Duration, limit int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if at.Sub(m.purged) >= rateLimitPurgeInterval {
+		for k, hits := range m.hits {
+			if last := hits.times[len(hits.times)-1]; !last.After(at.Add(-hits.window)) {
+				delete(m.hits, k)
+			}
+		}
+		m.purged = at
+	}
+
+	since := at.Add(-window)
+	times := []time.Time{}
+	if hits, ok := m.hits[key]; ok {
+		for _, hit := range hits.times {
+			if hit.After(since) {
+				times = append(times, hit)
+			}
+		}
+	}
+	if len(times) >= limit {
+		m.hits[key] = &rateLimitHits{times: times, window: window}
+		return false, nil
+	}
+	m.hits[key] = &rateLimitHits{times: append(times, at), window: window}
+	return true, nil
+}
+
+// Cancel removes a hit of the key.
+func (m *MemoryRateLimitStore) Cancel(key string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hits, ok := m.hits[key]
+	if !ok {
+		return nil
+	}
+	for i := len(hits.times) - 1; i >= 0; i-- {
+		if hits.times[i].Equal(at) {
+			hits.times = append(hits.times[:i], hits.times[i+1:]...)
+			break
+		}
+	}
+	if len(hits.times) == 0 {
+		delete(m.hits, key)
+	}
+	return nil
+}
+
+// RateLimitCondition is a condition which is fulfilled if the action was granted
+// less than limit times to the user in the sliding window.
+//
+// The counters are kept by service, policy, user (see rateLimitIdentity) and action,
+// and only the requests that are finally allowed are counted.
+type RateLimitCondition struct {
+	Limit  int
+	Window time.Duration
+
+	// service, policy, field and store are set when the policies of the service are loaded.
+	service string
+	policy  string
+	field   string
+	store   RateLimitStore
+}
+
+// UnmarshalJSON validates the limit and parses the window of the condition options.
+func (c *RateLimitCondition) UnmarshalJSON(data []byte) error {
+	var options struct {
+		Limit  int    `json:"limit"`
+		Window string `json:"window"`
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+	if options.Limit <= 0 {
+		return fmt.Errorf("invalid limit %d in %s", options.Limit, c.GetName())
+	}
+	window, err := time.ParseDuration(options.Window)
+	if err != nil || window <= 0 {
+		return fmt.Errorf("invalid window %q in %s", options.Window, c.GetName())
+	}
+	c.Limit = options.Limit
+	c.Window = window
+	return nil
+}
+
+// Fulfills returns true if the request's subject is under the limit.
+func (c *RateLimitCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	return c.fulfillsRequest(value, subjectEvaluation(r))
+}
+
+// fulfillsRequest returns true if the user of the request is under the limit. The
+// value is ignored. The hit is only recorded once the request is allowed (see reserve).
+func (c *RateLimitCondition) fulfillsRequest(value interface{}, e *evaluation) bool {
+	if c.store == nil {
+		return false
+	}
+	identity := rateLimitIdentity(e.principals)
+	count, err := c.store.Count(c.key(identity, e.request.Action), now().Add(-c.Window))
+	if err != nil {
+		log.Warningf("Could not count hits of policy %q: %s", c.policy, err)
+		return false
+	}
+	return count < c.Limit
+}
+
+// reserve records a hit of the user if it is still under the limit.
+func (c *RateLimitCondition) reserve(identity string, action string, at time.Time) bool {
+	if c.store == nil {
+		return false
+	}
+	ok, err := c.store.Reserve(c.key(identity, action), at, c.Window, c.Limit)
+	if err != nil {
+		log.Warningf("Could not record hit of policy %q: %s", c.policy, err)
+		return false
+	}
+	return ok
+}
+
+// cancel removes a hit recorded with reserve.
+func (c *RateLimitCondition) cancel(identity string, action string, at time.Time) {
+	if err := c.store.Cancel(c.key(identity, action), at); err != nil {
+		log.Warningf("Could not cancel hit of policy %q: %s", c.policy, err)
+	}
+}
+
+func (c *RateLimitCondition) key(identity string, action string) string {
+	return fmt.Sprintf("%q %q %q %q %q", c.service, c.policy, c.field, identity, action)
+}
+
+// rateLimitIdentity returns the principal that identifies the user in the counters:
+// the user ID (eg. "userid:ada") or, if there is none, the first principal. The limits
+// are thus shared by all the principals of the user, whichever matched the policy.
+func rateLimitIdentity(principals Principals) string {
+	for _, principal := range principals {
+		if strings.HasPrefix(principal, "userid:") {
+			return principal
+		}
+	}
+	if len(principals) == 0 {
+		return ""
+	}
+	return principals[0]
+}
+
+// GetName returns the condition's name.
+func (c *RateLimitCondition) GetName() string {
+	return "RateLimitCondition"
+}
+
+// bindRateLimits sets the store and keys of the rate limit conditions of the policy,
+// and returns the ones that must be counted when the policy grants a request (ie.
+// the top-level ones and those nested in AllOfCondition). Since they could not be
+// counted reliably, rate limits cannot be nested in AnyOfCondition or NotCondition.
+func bindRateLimits(conditions ladon.Conditions, service string, policy string, store RateLimitStore) ([]*RateLimitCondition, error) {
+	return bindNestedRateLimits(conditions, "", service, policy, store)
+}
+
+func bindNestedRateLimits(conditions ladon.Conditions, parent string, service string, policy string, store RateLimitStore) ([]*RateLimitCondition, error) {
+	limits := []*RateLimitCondition{}
+	for field, condition := range conditions {
+		switch c := condition.(type) {
+		case *RateLimitCondition:
+			c.service = service
+			c.policy = policy
+			c.field = parent + field
+			c.store = store
+			limits = append(limits, c)
+		case *AllOfCondition:
+			nested, err := bindNestedRateLimits(c.Conditions, parent+field+".", service, policy, store)
+			if err != nil {
+				return nil, err
+			}
+			limits = append(limits, nested...)
+		case *AnyOfCondition:
+			if hasRateLimit(c.Conditions) {
+				return nil, fmt.Errorf("RateLimitCondition cannot be nested in %s", c.GetName())
+			}
+		case *NotCondition:
+			if hasRateLimit(c.Conditions) {
+				return nil, fmt.Errorf("RateLimitCondition cannot be nested in %s", c.GetName())
+			}
+		}
+	}
+	return limits, nil
+}
+
+// hasRateLimit returns true if one of the conditions is (or contains) a rate limit.
+func hasRateLimit(conditions ladon.Conditions) bool {
+	for _, condition := range conditions {
+		switch c := condition.(type) {
+		case *RateLimitCondition:
+			return true
+		case *AllOfCondition:
+			if hasRateLimit(c.Conditions) {
+				return true
+			}
+		case *AnyOfCondition:
+			if hasRateLimit(c.Conditions) {
+				return true
+			}
+		case *NotCondition:
+			if hasRateLimit(c.Conditions) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func init() {
+	ladon.ConditionFactories[new(RateLimitCondition).GetName()] = func() ladon.Condition {
+		return new(RateLimitCondition)
+	}
+}
@@ -0,0 +1,382 @@
+package doorman
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitConditionOptions(t *testing.T) {
+	c := &RateLimitCondition{}
+	err := json.Unmarshal([]byte(`{"limit": 3, "window": "1h"}`), c)
+	require.Nil(t, err)
+	assert.Equal(t, 3, c.Limit)
+	assert.Equal(t, time.Hour, c.Window)
+
+	for _, options := range []string{`{"window": "1h"}`, `{"limit": -1, "window": "1h"}`} {
+		err = json.Unmarshal([]byte(options), &RateLimitCondition{})
+		assert.Contains(t, err.Error(), "invalid limit", options)
+	}
+	for _, options := range []string{`{"limit": 3}`, `{"limit": 3, "window": "hour"}`, `{"limit": 3, "window": "-1h"}`} {
+		err = json.Unmarshal([]byte(options), &RateLimitCondition{})
+		assert.Contains(t, err.Error(), "invalid window", options)
+	}
+
+	// Not bound to a service.
+	assert.False(t, c.Fulfills(nil, ladonRequest(&Request{})))
+}
+
+func rateLimitDoorman(t *testing.T) *LadonDoorman {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "signoff-twice-an-hour",
+					Principals: Principals{"<userid:.*>"},
+					Actions:    []string{"signoff", "export"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"quota": Condition{
+							Type: "RateLimitCondition",
+							Options: map[string]interface{}{
+								"limit":  2,
+								"window": "1h",
+							},
+						},
+					},
+					Effect: "allow",
+				},
+				Policy{
+					ID:         "no-export-of-secrets",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"export"},
+					Resources:  []string{"secrets"},
+					Effect:     "deny",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+	return d
+}
+
+func TestRateLimitCondition(t *testing.T) {
+	defer setNow(time.Date(2018, 1, 31, 12, 0, 0, 0, time.UTC))()
+
+	d := rateLimitDoorman(t)
+	allowed := func(user string, action string, resource string) bool {
+		return d.IsAllowed("a", &Request{
+			Principals: Principals{"group:reviewers", user},
+			Action:     action,
+			Resource:   resource,
+		}).Allowed
+	}
+
+	assert.True(t, allowed("userid:alice", "signoff", "a"))
+	// Other users and actions have their own counters.
+	assert.True(t, allowed("userid:bob", "signoff", "a"))
+	assert.True(t, allowed("userid:alice", "export", "a"))
+
+	setNow(time.Date(2018, 1, 31, 12, 30, 0, 0, time.UTC))
+	assert.True(t, allowed("userid:alice", "signoff", "b"))
+	assert.False(t, allowed("userid:alice", "signoff", "c"))
+
+	// Denied requests are not counted.
+	assert.False(t, allowed("userid:alice", "export", "secrets"))
+	assert.True(t, allowed("userid:alice", "export", "b"))
+
+	// Sliding window.
+	setNow(time.Date(2018, 1, 31, 13, 0, 1, 0, time.UTC))
+	assert.True(t, allowed("userid:alice", "signoff", "c"))
+	assert.False(t, allowed("userid:alice", "signoff", "d"))
+
+	// Not granted to other principals of the user.
+	assert.False(t, allowed("group:other", "signoff", "d"))
+}
+
+func TestRateLimitSeveralPrincipals(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "once-an-hour",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"<.*>"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"quota": Condition{
+							Type:    "RateLimitCondition",
+							Options: map[string]interface{}{"limit": 1, "window": "1h"},
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	// The limit is shared by all the principals of the user.
+	request := &Request{
+		Principals: Principals{"userid:alice", "email:alice@mozilla.com", "group:admins", "tag:staff"},
+		Action:     "read",
+	}
+	assert.True(t, d.IsAllowed("a", request).Allowed)
+	for i := 0; i < 3; i++ {
+		assert.False(t, d.IsAllowed("a", request).Allowed)
+	}
+	assert.True(t, d.IsAllowed("a", &Request{Principals: Principals{"userid:bob", "tag:staff"}, Action: "read"}).Allowed)
+}
+
+func TestRateLimitShadowPolicies(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "once-an-hour",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"read"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"quota": Condition{
+							Type:    "RateLimitCondition",
+							Options: map[string]interface{}{"limit": 1, "window": "1h"},
+						},
+					},
+					Effect: "allow",
+				},
+				Policy{
+					ID:         "no-write",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"write"},
+					Resources:  []string{"<.*>"},
+					Effect:     "deny",
+					Mode:       ShadowMode,
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	// The shadow decision does not see the hit of the allowed request.
+	request := &Request{Principals: Principals{"userid:alice"}, Action: "read"}
+	assert.True(t, d.IsAllowed("a", request).Allowed)
+	assert.False(t, d.IsAllowed("a", request).Allowed)
+	assert.Empty(t, d.ShadowStats())
+}
+
+func TestRateLimitConcurrentRequests(t *testing.T) {
+	d := rateLimitDoorman(t)
+
+	var wg sync.WaitGroup
+	var granted int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decision := d.IsAllowed("a", &Request{
+				Principals: Principals{"userid:alice"},
+				Action:     "signoff",
+				Resource:   "a",
+			})
+			if decision.Allowed {
+				atomic.AddInt32(&granted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), granted)
+}
+
+func TestRateLimitReservationsCancelled(t *testing.T) {
+	d := NewDefaultLadon()
+	store := NewMemoryRateLimitStore()
+	d.SetRateLimitStore(store)
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "1",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"<.*>"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"minute": Condition{
+							Type:    "RateLimitCondition",
+							Options: map[string]interface{}{"limit": 5, "window": "1m"},
+						},
+						"hour": Condition{
+							Type:    "RateLimitCondition",
+							Options: map[string]interface{}{"limit": 1, "window": "1h"},
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+	s := d.snapshot()
+	limits := s.limits["a"]["1"]
+	require.Len(t, limits, 2)
+	hour := limits[0]
+	if hour.Limit != 1 {
+		hour = limits[1]
+	}
+
+	defer setNow(time.Date(2018, 1, 31, 12, 0, 0, 0, time.UTC))()
+	// The hourly limit is reached by another request after the decision.
+	r := ladonRequest(&Request{Action: "read"})
+	decision, matches := s.evaluate(s.indexes["a"], "a", Principals{"userid:alice"}, r)
+	require.True(t, decision.Allowed)
+	require.True(t, hour.reserve("userid:alice", "read", now().Add(-time.Second)))
+
+	assert.False(t, s.reserve("a", Principals{"userid:alice"}, decision, matches, r))
+	// No hit is left in the other counter.
+	for _, limit := range limits {
+		count, _ := store.Count(limit.key("userid:alice", "read"), now().Add(-limit.Window))
+		expected := 0
+		if limit == hour {
+			expected = 1
+		}
+		assert.Equal(t, expected, count, limit.Window)
+	}
+	assert.False(t, d.IsAllowed("a", &Request{Principals: Principals{"userid:alice"}, Action: "read"}).Allowed)
+}
+
+func TestRateLimitNested(t *testing.T) {
+	for _, composite := range []string{"AnyOfCondition", "NotCondition"} {
+		d := NewDefaultLadon()
+		err := d.LoadPolicies(ServicesConfig{
+			ServiceConfig{
+				Service: "a",
+				Policies: Policies{
+					Policy{
+						ID:         "1",
+						Principals: Principals{"<.*>"},
+						Actions:    []string{"<.*>"},
+						Resources:  []string{"<.*>"},
+						Conditions: Conditions{
+							"nested": Condition{
+								Type: composite,
+								Options: map[string]interface{}{
+									"conditions": map[string]interface{}{
+										"quota": map[string]interface{}{
+											"type":    "RateLimitCondition",
+											"options": map[string]interface{}{"limit": 1, "window": "1m"},
+										},
+									},
+								},
+							},
+						},
+						Effect: "allow",
+					},
+				},
+			},
+		})
+		require.NotNil(t, err, composite)
+		assert.Contains(t, err.Error(), "RateLimitCondition cannot be nested in "+composite)
+	}
+}
+
+func TestRateLimitPermissionsNotCounted(t *testing.T) {
+	d := rateLimitDoorman(t)
+	request := &Request{
+		Principals: Principals{"userid:alice", "group:reviewers"},
+		Resource:   "a",
+	}
+	for i := 0; i < 3; i++ {
+		assert.Len(t, d.Permissions("a", request), 2)
+	}
+}
+
+type failingRateLimitStore struct{}
+
+func (f failingRateLimitStore) Count(key string, since time.Time) (int, error) {
+	return 0, assert.AnError
+}
+
+func (f failingRateLimitStore) Reserve(key string, at time.Time, window time.Duration, limit int) (bool, error) {
+	return false, assert.AnError
+}
+
+func (f failingRateLimitStore) Cancel(key string, at time.Time) error {
+	return assert.AnError
+}
+
+func TestSetRateLimitStore(t *testing.T) {
+	d := NewDefaultLadon()
+	d.SetRateLimitStore(failingRateLimitStore{})
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "1",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"<.*>"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"quota": Condition{
+							Type:    "RateLimitCondition",
+							Options: map[string]interface{}{"limit": 1, "window": "1m"},
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	// Denied if the counters cannot be read.
+	assert.False(t, d.IsAllowed("a", &Request{Principals: Principals{"userid:alice"}, Action: "read"}).Allowed)
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	start := time.Date(2018, 1, 31, 12, 0, 0, 0, time.UTC)
+	ok, _ := store.Reserve("k", start, time.Minute, 2)
+	assert.True(t, ok)
+	ok, _ = store.Reserve("k", start.Add(30*time.Second), time.Minute, 2)
+	assert.True(t, ok)
+
+	count, _ := store.Count("k", start.Add(-time.Minute))
+	assert.Equal(t, 2, count)
+	count, _ = store.Count("k", start)
+	assert.Equal(t, 1, count)
+
+	// Limit reached.
+	ok, _ = store.Reserve("k", start.Add(45*time.Second), time.Minute, 2)
+	assert.False(t, ok)
+	count, _ = store.Count("k", start.Add(-time.Minute))
+	assert.Equal(t, 2, count)
+
+	// Cancelled hits are not counted.
+	store.Cancel("k", start.Add(30*time.Second))
+	count, _ = store.Count("k", start.Add(-time.Minute))
+	assert.Equal(t, 1, count)
+
+	// Old hits are dropped.
+	store.Reserve("k", start.Add(2*time.Minute), time.Minute, 2)
+	assert.Len(t, store.hits["k"].times, 1)
+
+	// Idle keys are purged.
+	store.Reserve("idle", start.Add(2*time.Minute), time.Second, 2)
+	store.Reserve("other", start.Add(4*time.Minute), time.Minute, 2)
+	assert.NotContains(t, store.hits, "idle")
+	assert.NotContains(t, store.hits, "k")
+	assert.Contains(t, store.hits, "other")
+}
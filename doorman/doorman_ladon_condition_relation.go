@@ -0,0 +1,85 @@
+package doorman
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ory/ladon"
+	log "github.com/sirupsen/logrus"
+)
+
+// RelationCondition is a condition which is fulfilled if one of the principals has
+// the relation with the object, according to the relationship tuples of the service.
+type RelationCondition struct {
+	Relation string `json:"relation"`
+
+	// graph is set when the policies of the service are loaded.
+	graph *relationGraph
+}
+
+// UnmarshalJSON validates the relation of the condition options.
+func (c *RelationCondition) UnmarshalJSON(data []byte) error {
+	type options RelationCondition
+	if err := json.Unmarshal(data, (*options)(c)); err != nil {
+		return err
+	}
+	if c.Relation == "" {
+		return fmt.Errorf("missing relation in %s", c.GetName())
+	}
+	return nil
+}
+
+// Fulfills returns true if the request's subject has the relation with the object.
+func (c *RelationCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	return c.fulfillsRequest(value, subjectEvaluation(r))
+}
+
+// fulfillsRequest returns true if one of the principals has the relation with the
+// object given in the value, or with the request resource if the value is missing.
+func (c *RelationCondition) fulfillsRequest(value interface{}, e *evaluation) bool {
+	if c.graph == nil {
+		return false
+	}
+	object := e.request.Resource
+	if value != nil {
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		object = s
+	}
+	ok, err := c.graph.check(object, c.Relation, e.principals)
+	if err != nil {
+		log.Warningf("Could not check relation %q of %q: %s", c.Relation, object, err)
+		return false
+	}
+	return ok
+}
+
+// GetName returns the condition's name.
+func (c *RelationCondition) GetName() string {
+	return "RelationCondition"
+}
+
+// bindRelations sets the relation graph of the service in the relation conditions,
+// including the nested ones.
+func bindRelations(conditions ladon.Conditions, graph *relationGraph) {
+	for _, condition := range conditions {
+		switch c := condition.(type) {
+		case *RelationCondition:
+			c.graph = graph
+		case *AllOfCondition:
+			bindRelations(c.Conditions, graph)
+		case *AnyOfCondition:
+			bindRelations(c.Conditions, graph)
+		case *NotCondition:
+			bindRelations(c.Conditions, graph)
+		}
+	}
+}
+
+func init() {
+	ladon.ConditionFactories[new(RelationCondition).GetName()] = func() ladon.Condition {
+		return new(RelationCondition)
+	}
+}
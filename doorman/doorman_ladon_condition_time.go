@@ -0,0 +1,189 @@
+package doorman
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ory/ladon"
+)
+
+// now returns the server time. Time conditions are evaluated against the server
+// time, not against values from the request context.
+var now = time.Now
+
+// parseDate parses a RFC 3339 timestamp (eg. "2018-01-31T18:00:00Z").
+func parseDate(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q: %s", value, err)
+	}
+	return t, nil
+}
+
+// DateBeforeCondition is a condition which is fulfilled if the server time is before the date.
+type DateBeforeCondition struct {
+	Date time.Time
+}
+
+// UnmarshalJSON parses the date of the condition options.
+func (c *DateBeforeCondition) UnmarshalJSON(data []byte) error {
+	var options struct {
+		Date string `json:"date"`
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+	date, err := parseDate(options.Date)
+	c.Date = date
+	return err
+}
+
+// Fulfills returns true if the server time is strictly before the date. The value is ignored.
+func (c *DateBeforeCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	return now().Before(c.Date)
+}
+
+// GetName returns the condition's name.
+func (c *DateBeforeCondition) GetName() string {
+	return "DateBeforeCondition"
+}
+
+// DateAfterCondition is a condition which is fulfilled if the server time is after the date.
+type DateAfterCondition struct {
+	Date time.Time
+}
+
+// UnmarshalJSON parses the date of the condition options.
+func (c *DateAfterCondition) UnmarshalJSON(data []byte) error {
+	var options struct {
+		Date string `json:"date"`
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+	date, err := parseDate(options.Date)
+	c.Date = date
+	return err
+}
+
+// Fulfills returns true if the server time is equal or after the date. The value is ignored.
+func (c *DateAfterCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	return !now().Before(c.Date)
+}
+
+// GetName returns the condition's name.
+func (c *DateAfterCondition) GetName() string {
+	return "DateAfterCondition"
+}
+
+// TimeWindowCondition is a condition which is fulfilled if the server time is within
+// the days of week and hours, in the configured timezone.
+type TimeWindowCondition struct {
+	// Weekdays are the allowed days (any if empty).
+	Weekdays map[time.Weekday]bool
+	// From and To are the allowed minutes of the day (any if equal).
+	// The window spans midnight if From is after To (eg. "22:00" to "06:00").
+	From, To int
+	Location *time.Location
+}
+
+// UnmarshalJSON parses the condition options, for example:
+//
+//	{"weekdays": ["monday", "friday"], "from": "09:00", "to": "17:30", "timezone": "Europe/Paris"}
+func (c *TimeWindowCondition) UnmarshalJSON(data []byte) error {
+	var options struct {
+		Weekdays []string `json:"weekdays"`
+		From     string   `json:"from"`
+		To       string   `json:"to"`
+		Timezone string   `json:"timezone"`
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+
+	c.Weekdays = map[time.Weekday]bool{}
+	for _, name := range options.Weekdays {
+		weekday, err := parseWeekday(name)
+		if err != nil {
+			return err
+		}
+		c.Weekdays[weekday] = true
+	}
+
+	var err error
+	if c.From, err = parseHour(options.From); err != nil {
+		return err
+	}
+	if c.To, err = parseHour(options.To); err != nil {
+		return err
+	}
+
+	// Default is UTC.
+	if c.Location, err = time.LoadLocation(options.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q: %s", options.Timezone, err)
+	}
+	return nil
+}
+
+// parseWeekday returns the day of week from its name (eg. "monday").
+func parseWeekday(name string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", name)
+}
+
+// parseHour returns the minutes of the day from a time of the day (eg. "17:30").
+func parseHour(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hour %q: %s", value, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Fulfills returns true if the server time is within the window. The value is ignored.
+func (c *TimeWindowCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	location := c.Location
+	if location == nil {
+		location = time.UTC
+	}
+	t := now().In(location)
+
+	if len(c.Weekdays) > 0 && !c.Weekdays[t.Weekday()] {
+		return false
+	}
+
+	minutes := t.Hour()*60 + t.Minute()
+	switch {
+	case c.From < c.To:
+		return c.From <= minutes && minutes < c.To
+	case c.From > c.To:
+		return c.From <= minutes || minutes < c.To
+	}
+	return true
+}
+
+// GetName returns the condition's name.
+func (c *TimeWindowCondition) GetName() string {
+	return "TimeWindowCondition"
+}
+
+func init() {
+	ladon.ConditionFactories[new(DateBeforeCondition).GetName()] = func() ladon.Condition {
+		return new(DateBeforeCondition)
+	}
+	ladon.ConditionFactories[new(DateAfterCondition).GetName()] = func() ladon.Condition {
+		return new(DateAfterCondition)
+	}
+	ladon.ConditionFactories[new(TimeWindowCondition).GetName()] = func() ladon.Condition {
+		return new(TimeWindowCondition)
+	}
+}
@@ -0,0 +1,155 @@
+package doorman
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setNow freezes the server time, and returns a function to restore it.
+func setNow(t time.Time) func() {
+	now = func() time.Time { return t }
+	return func() { now = time.Now }
+}
+
+func TestDateConditions(t *testing.T) {
+	before := &DateBeforeCondition{}
+	err := json.Unmarshal([]byte(`{"date": "2018-01-31T18:00:00+01:00"}`), before)
+	require.Nil(t, err)
+	after := &DateAfterCondition{}
+	err = json.Unmarshal([]byte(`{"date": "2018-01-31T18:00:00+01:00"}`), after)
+	require.Nil(t, err)
+
+	defer setNow(time.Date(2018, 1, 31, 16, 59, 0, 0, time.UTC))()
+	assert.True(t, before.Fulfills(nil, nil))
+	assert.False(t, after.Fulfills(nil, nil))
+
+	setNow(time.Date(2018, 1, 31, 17, 0, 0, 0, time.UTC))
+	assert.False(t, before.Fulfills(nil, nil))
+	assert.True(t, after.Fulfills(nil, nil))
+
+	// Bad dates.
+	err = json.Unmarshal([]byte(`{"date": "2018-01-31"}`), before)
+	assert.Contains(t, err.Error(), "invalid date \"2018-01-31\"")
+	err = json.Unmarshal([]byte(`null`), after)
+	assert.Contains(t, err.Error(), "invalid date \"\"")
+}
+
+func TestTimeWindowCondition(t *testing.T) {
+	c := &TimeWindowCondition{}
+	err := json.Unmarshal([]byte(`{
+		"weekdays": ["Monday", "tuesday"],
+		"from": "09:00",
+		"to": "17:30",
+		"timezone": "Europe/Paris"
+	}`), c)
+	require.Nil(t, err)
+
+	// Monday 2018-01-29, 08:00 UTC is 09:00 in Paris.
+	defer setNow(time.Date(2018, 1, 29, 8, 0, 0, 0, time.UTC))()
+	assert.True(t, c.Fulfills(nil, nil))
+	setNow(time.Date(2018, 1, 29, 7, 59, 0, 0, time.UTC))
+	assert.False(t, c.Fulfills(nil, nil))
+	setNow(time.Date(2018, 1, 30, 16, 29, 0, 0, time.UTC))
+	assert.True(t, c.Fulfills(nil, nil))
+	setNow(time.Date(2018, 1, 30, 16, 30, 0, 0, time.UTC))
+	assert.False(t, c.Fulfills(nil, nil))
+	// Wednesday.
+	setNow(time.Date(2018, 1, 31, 10, 0, 0, 0, time.UTC))
+	assert.False(t, c.Fulfills(nil, nil))
+
+	// Over midnight, any day, UTC.
+	c = &TimeWindowCondition{}
+	err = json.Unmarshal([]byte(`{"from": "22:00", "to": "06:00"}`), c)
+	require.Nil(t, err)
+	setNow(time.Date(2018, 1, 31, 23, 0, 0, 0, time.UTC))
+	assert.True(t, c.Fulfills(nil, nil))
+	setNow(time.Date(2018, 1, 31, 5, 59, 0, 0, time.UTC))
+	assert.True(t, c.Fulfills(nil, nil))
+	setNow(time.Date(2018, 1, 31, 12, 0, 0, 0, time.UTC))
+	assert.False(t, c.Fulfills(nil, nil))
+
+	// Bad options.
+	for _, options := range []string{
+		`{"weekdays": ["someday"]}`,
+		`{"from": "9h"}`,
+		`{"to": "25:00"}`,
+		`{"timezone": "Mars/Olympus"}`,
+	} {
+		err = json.Unmarshal([]byte(options), &TimeWindowCondition{})
+		assert.NotNil(t, err, options)
+	}
+}
+
+func TestPolicyValidity(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "contractors",
+					Principals: Principals{"userid:bob"},
+					Actions:    []string{"deploy"},
+					Resources:  []string{"<.*>"},
+					Effect:     "allow",
+					NotBefore:  "2018-01-01T00:00:00Z",
+					NotAfter:   "2018-02-01T00:00:00Z",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	request := &Request{
+		Principals: Principals{"userid:bob"},
+		Action:     "deploy",
+		Resource:   "prod",
+	}
+	defer setNow(time.Date(2017, 12, 31, 0, 0, 0, 0, time.UTC))()
+	assert.False(t, d.IsAllowed("a", request).Allowed)
+	setNow(time.Date(2018, 1, 15, 0, 0, 0, 0, time.UTC))
+	assert.True(t, d.IsAllowed("a", request).Allowed)
+	setNow(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC))
+	assert.False(t, d.IsAllowed("a", request).Allowed)
+
+	// Bad dates.
+	err = d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Source:  "a.yaml",
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:       "1",
+					Effect:   "allow",
+					NotAfter: "tomorrow",
+				},
+			},
+		},
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid date \"tomorrow\"")
+	assert.Contains(t, err.Error(), "in policy \"1\" (source \"a.yaml\")")
+
+	// Missing date in condition.
+	err = d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID: "1",
+					Conditions: Conditions{
+						"deadline": Condition{
+							Type: "DateBeforeCondition",
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,260 @@
+package doorman
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ory/ladon"
+)
+
+// toFloat returns the number of a context value (float64 when decoded from JSON).
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// toStrings returns the strings of a context list ([]interface{} when decoded from JSON).
+func toStrings(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case []string:
+		return v, true
+	case Principals:
+		return v, true
+	case []interface{}:
+		l := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			l[i] = s
+		}
+		return l, true
+	}
+	return nil, false
+}
+
+// NumericComparisonCondition is a condition which is fulfilled if the given number
+// satisfies every specified comparison.
+type NumericComparisonCondition struct {
+	LessThan         *float64  `json:"lt"`
+	LessThanEqual    *float64  `json:"lte"`
+	GreaterThan      *float64  `json:"gt"`
+	GreaterThanEqual *float64  `json:"gte"`
+	Between          []float64 `json:"between"`
+}
+
+// UnmarshalJSON validates the comparisons of the condition options.
+func (c *NumericComparisonCondition) UnmarshalJSON(data []byte) error {
+	type options NumericComparisonCondition
+	if err := json.Unmarshal(data, (*options)(c)); err != nil {
+		return err
+	}
+	if c.LessThan == nil && c.LessThanEqual == nil && c.GreaterThan == nil && c.GreaterThanEqual == nil && c.Between == nil {
+		return fmt.Errorf("missing comparison in %s", c.GetName())
+	}
+	if c.Between != nil && (len(c.Between) != 2 || c.Between[0] > c.Between[1]) {
+		return fmt.Errorf("invalid between %v in %s", c.Between, c.GetName())
+	}
+	return nil
+}
+
+// Fulfills returns true if the given value is a number that satisfies the comparisons.
+// The bounds of between are included.
+func (c *NumericComparisonCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	n, ok := toFloat(value)
+	if !ok {
+		return false
+	}
+	if c.LessThan != nil && !(n < *c.LessThan) {
+		return false
+	}
+	if c.LessThanEqual != nil && !(n <= *c.LessThanEqual) {
+		return false
+	}
+	if c.GreaterThan != nil && !(n > *c.GreaterThan) {
+		return false
+	}
+	if c.GreaterThanEqual != nil && !(n >= *c.GreaterThanEqual) {
+		return false
+	}
+	if c.Between != nil && (n < c.Between[0] || n > c.Between[1]) {
+		return false
+	}
+	return true
+}
+
+// GetName returns the condition's name.
+func (c *NumericComparisonCondition) GetName() string {
+	return "NumericComparisonCondition"
+}
+
+// valuesOptions are the options of conditions on a set of strings.
+type valuesOptions struct {
+	Values []string `json:"values"`
+}
+
+// unmarshalValues returns the set of values of the condition options.
+func unmarshalValues(name string, data []byte) (map[string]bool, error) {
+	var options valuesOptions
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, err
+	}
+	if len(options.Values) == 0 {
+		return nil, fmt.Errorf("missing values in %s", name)
+	}
+	set := map[string]bool{}
+	for _, v := range options.Values {
+		set[v] = true
+	}
+	return set, nil
+}
+
+// StringInSetCondition is a condition which is fulfilled if the given string is one of the values.
+type StringInSetCondition struct {
+	Values map[string]bool
+}
+
+// UnmarshalJSON reads the values of the condition options.
+func (c *StringInSetCondition) UnmarshalJSON(data []byte) (err error) {
+	c.Values, err = unmarshalValues(c.GetName(), data)
+	return err
+}
+
+// Fulfills returns true if the given value is a string among the values.
+func (c *StringInSetCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	s, ok := value.(string)
+	return ok && c.Values[s]
+}
+
+// GetName returns the condition's name.
+func (c *StringInSetCondition) GetName() string {
+	return "StringInSetCondition"
+}
+
+// ListContainsAnyCondition is a condition which is fulfilled if the given list
+// contains at least one of the values.
+type ListContainsAnyCondition struct {
+	Values map[string]bool
+}
+
+// UnmarshalJSON reads the values of the condition options.
+func (c *ListContainsAnyCondition) UnmarshalJSON(data []byte) (err error) {
+	c.Values, err = unmarshalValues(c.GetName(), data)
+	return err
+}
+
+// Fulfills returns true if the given value is a list of strings that contains one of the values.
+func (c *ListContainsAnyCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	l, ok := toStrings(value)
+	if !ok {
+		return false
+	}
+	for _, s := range l {
+		if c.Values[s] {
+			return true
+		}
+	}
+	return false
+}
+
+// GetName returns the condition's name.
+func (c *ListContainsAnyCondition) GetName() string {
+	return "ListContainsAnyCondition"
+}
+
+// ListContainsAllCondition is a condition which is fulfilled if the given list
+// contains every value.
+type ListContainsAllCondition struct {
+	Values map[string]bool
+}
+
+// UnmarshalJSON reads the values of the condition options.
+func (c *ListContainsAllCondition) UnmarshalJSON(data []byte) (err error) {
+	c.Values, err = unmarshalValues(c.GetName(), data)
+	return err
+}
+
+// Fulfills returns true if the given value is a list of strings that contains all the values.
+func (c *ListContainsAllCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	l, ok := toStrings(value)
+	if !ok {
+		return false
+	}
+	found := map[string]bool{}
+	for _, s := range l {
+		if c.Values[s] {
+			found[s] = true
+		}
+	}
+	return len(found) == len(c.Values)
+}
+
+// GetName returns the condition's name.
+func (c *ListContainsAllCondition) GetName() string {
+	return "ListContainsAllCondition"
+}
+
+// BooleanEqualCondition is a condition which is fulfilled if the given value is the boolean.
+type BooleanEqualCondition struct {
+	Equals bool `json:"equals"`
+}
+
+// UnmarshalJSON requires the boolean of the condition options, since false is
+// not a default value.
+func (c *BooleanEqualCondition) UnmarshalJSON(data []byte) error {
+	var options struct {
+		Equals *bool `json:"equals"`
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+	if options.Equals == nil {
+		return fmt.Errorf("missing equals in %s", c.GetName())
+	}
+	c.Equals = *options.Equals
+	return nil
+}
+
+// Fulfills returns true if the given value is a boolean equal to the specified one.
+func (c *BooleanEqualCondition) Fulfills(value interface{}, r *ladon.Request) bool {
+	b, ok := value.(bool)
+	return ok && b == c.Equals
+}
+
+// GetName returns the condition's name.
+func (c *BooleanEqualCondition) GetName() string {
+	return "BooleanEqualCondition"
+}
+
+func init() {
+	ladon.ConditionFactories[new(NumericComparisonCondition).GetName()] = func() ladon.Condition {
+		return new(NumericComparisonCondition)
+	}
+	ladon.ConditionFactories[new(StringInSetCondition).GetName()] = func() ladon.Condition {
+		return new(StringInSetCondition)
+	}
+	ladon.ConditionFactories[new(ListContainsAnyCondition).GetName()] = func() ladon.Condition {
+		return new(ListContainsAnyCondition)
+	}
+	ladon.ConditionFactories[new(ListContainsAllCondition).GetName()] = func() ladon.Condition {
+		return new(ListContainsAllCondition)
+	}
+	ladon.ConditionFactories[new(BooleanEqualCondition).GetName()] = func() ladon.Condition {
+		return new(BooleanEqualCondition)
+	}
+}
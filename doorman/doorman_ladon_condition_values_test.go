@@ -0,0 +1,127 @@
+package doorman
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ory/ladon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValuesConditions(t *testing.T) {
+	for _, test := range []struct {
+		condition ladon.Condition
+		options   string
+		value     interface{}
+		expected  bool
+	}{
+		// Numbers.
+		{&NumericComparisonCondition{}, `{"lt": 10}`, 9.5, true},
+		{&NumericComparisonCondition{}, `{"lt": 10}`, 10.0, false},
+		{&NumericComparisonCondition{}, `{"lte": 10}`, 10, true},
+		{&NumericComparisonCondition{}, `{"gt": 10}`, 10.0, false},
+		{&NumericComparisonCondition{}, `{"gte": 10, "lt": 20}`, 10.0, true},
+		{&NumericComparisonCondition{}, `{"gte": 10, "lt": 20}`, 20.0, false},
+		{&NumericComparisonCondition{}, `{"between": [1, 3]}`, 3.0, true},
+		{&NumericComparisonCondition{}, `{"between": [1, 3]}`, 0.9, false},
+		{&NumericComparisonCondition{}, `{"between": [1, 3]}`, "2", false},
+		{&NumericComparisonCondition{}, `{"between": [1, 3]}`, nil, false},
+		// Set of strings.
+		{&StringInSetCondition{}, `{"values": ["stage", "dev"]}`, "dev", true},
+		{&StringInSetCondition{}, `{"values": ["stage", "dev"]}`, "prod", false},
+		{&StringInSetCondition{}, `{"values": ["stage", "dev"]}`, []interface{}{"dev"}, false},
+		// Lists.
+		{&ListContainsAnyCondition{}, `{"values": ["bug", "security"]}`, []interface{}{"feature", "security"}, true},
+		{&ListContainsAnyCondition{}, `{"values": ["bug", "security"]}`, []string{"feature"}, false},
+		{&ListContainsAnyCondition{}, `{"values": ["bug", "security"]}`, []interface{}{"bug", 42.0}, false},
+		{&ListContainsAnyCondition{}, `{"values": ["bug", "security"]}`, "bug", false},
+		{&ListContainsAllCondition{}, `{"values": ["bug", "security"]}`, []interface{}{"security", "p1", "bug"}, true},
+		{&ListContainsAllCondition{}, `{"values": ["bug", "security"]}`, []interface{}{"security", "security"}, false},
+		{&ListContainsAllCondition{}, `{"values": ["userid:alice"]}`, Principals{"userid:alice"}, true},
+		// Booleans.
+		{&BooleanEqualCondition{}, `{"equals": true}`, true, true},
+		{&BooleanEqualCondition{}, `{"equals": false}`, false, true},
+		{&BooleanEqualCondition{}, `{"equals": false}`, nil, false},
+		{&BooleanEqualCondition{}, `{"equals": true}`, "true", false},
+	} {
+		err := json.Unmarshal([]byte(test.options), test.condition)
+		require.Nil(t, err)
+		assert.Equal(t, test.expected, test.condition.Fulfills(test.value, nil), "%s %s %v", test.condition.GetName(), test.options, test.value)
+	}
+}
+
+func TestBadValuesConditions(t *testing.T) {
+	for _, test := range []struct {
+		condition ladon.Condition
+		options   string
+		expected  string
+	}{
+		{&NumericComparisonCondition{}, `null`, "missing comparison in NumericComparisonCondition"},
+		{&NumericComparisonCondition{}, `{"between": [1]}`, "invalid between [1] in NumericComparisonCondition"},
+		{&NumericComparisonCondition{}, `{"between": [3, 1]}`, "invalid between [3 1] in NumericComparisonCondition"},
+		{&NumericComparisonCondition{}, `{"lt": "a"}`, "cannot unmarshal string"},
+		{&StringInSetCondition{}, `{}`, "missing values in StringInSetCondition"},
+		{&ListContainsAnyCondition{}, `{"values": []}`, "missing values in ListContainsAnyCondition"},
+		{&ListContainsAllCondition{}, `null`, "missing values in ListContainsAllCondition"},
+		{&BooleanEqualCondition{}, `null`, "missing equals in BooleanEqualCondition"},
+		{&BooleanEqualCondition{}, `{"equal": true}`, "missing equals in BooleanEqualCondition"},
+	} {
+		err := json.Unmarshal([]byte(test.options), test.condition)
+		require.NotNil(t, err, test.options)
+		assert.Contains(t, err.Error(), test.expected)
+	}
+}
+
+func TestValuesConditionsFromJSON(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "1",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"pay"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"amount": Condition{
+							Type: "NumericComparisonCondition",
+							Options: map[string]interface{}{
+								"lte": 1000,
+							},
+						},
+						"labels": Condition{
+							Type: "ListContainsAllCondition",
+							Options: map[string]interface{}{
+								"values": []interface{}{"approved"},
+							},
+						},
+						"verified": Condition{
+							Type: "BooleanEqualCondition",
+							Options: map[string]interface{}{
+								"equals": true,
+							},
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	// Context as decoded by BindJSON.
+	var request Request
+	err = json.Unmarshal([]byte(`{
+		"principals": ["userid:bob"],
+		"action": "pay",
+		"resource": "invoice",
+		"context": {"amount": 999.99, "labels": ["approved", "urgent"], "verified": true}
+	}`), &request)
+	require.Nil(t, err)
+	assert.True(t, d.IsAllowed("a", &request).Allowed)
+
+	request.Context["amount"] = 1000.01
+	assert.False(t, d.IsAllowed("a", &request).Allowed)
+}
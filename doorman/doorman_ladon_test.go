@@ -2,11 +2,13 @@ package doorman
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var sampleConfigs ServicesConfig
@@ -159,6 +161,15 @@ func TestBadServicesConfig(t *testing.T) {
 	})
 	assert.NotNil(t, err)
 
+	// Unknown combining algorithm
+	err = d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service:            "a",
+			CombiningAlgorithm: "random",
+		},
+	})
+	assert.NotNil(t, err)
+
 	// Unknown condition type
 	err = d.LoadPolicies(ServicesConfig{
 		ServiceConfig{
@@ -181,12 +192,12 @@ func TestBadServicesConfig(t *testing.T) {
 
 func TestLoadPoliciesTwice(t *testing.T) {
 	doorman := sampleDoorman()
-	loaded, _ := doorman.ladons["https://sample.yaml"].Manager.GetAll(0, maxInt)
+	loaded := doorman.snapshot().policies["https://sample.yaml"]
 	assert.Equal(t, 6, len(loaded))
 
 	// Second load.
 	doorman.LoadPolicies(sampleConfigs)
-	loaded, _ = doorman.ladons["https://sample.yaml"].Manager.GetAll(0, maxInt)
+	loaded = doorman.snapshot().policies["https://sample.yaml"]
 	assert.Equal(t, 6, len(loaded))
 
 	// Load bad policies, does not affect existing.
@@ -196,7 +207,7 @@ func TestLoadPoliciesTwice(t *testing.T) {
 		},
 	})
 	assert.Contains(t, err.Error(), "\"http://perlin-pinpin\" does not use the https:// scheme")
-	_, ok := doorman.ladons["https://sample.yaml"]
+	_, ok := doorman.snapshot().services["https://sample.yaml"]
 	assert.True(t, ok)
 }
 
@@ -211,10 +222,56 @@ func TestIsAllowed(t *testing.T) {
 	}
 
 	// Check service
-	allowed := doorman.IsAllowed("https://sample.yaml", request)
-	assert.True(t, allowed)
-	allowed = doorman.IsAllowed("https://bad.service", request)
-	assert.False(t, allowed)
+	decision := doorman.IsAllowed("https://sample.yaml", request)
+	assert.True(t, decision.Allowed)
+	decision = doorman.IsAllowed("https://bad.service", request)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, ReasonUnknownService, decision.Reason)
+}
+
+func TestIsAllowedDecision(t *testing.T) {
+	doorman := sampleDoorman()
+
+	// Allowed by policy #1 for the tag.
+	decision := doorman.IsAllowed("https://sample.yaml", &Request{
+		Principals: Principals{"userid:maria", "tag:admins"},
+		Action:     "update",
+		Resource:   "server.org/blocklist:onecrl",
+	})
+	assert.Equal(t, &Decision{
+		Allowed:   true,
+		Policies:  []string{"1"},
+		Principal: "tag:admins",
+		Reason:    ReasonAllowed,
+	}, decision)
+
+	// Explicitly denied by policy #2.
+	decision = doorman.IsAllowed("https://sample.yaml", &Request{
+		Principals: Principals{"userid:foo"},
+		Action:     "update",
+		Resource:   "server.org/blocklist:onecrl",
+		Context: Context{
+			"planet": "mars",
+		},
+	})
+	assert.Equal(t, &Decision{
+		Allowed:   false,
+		Policies:  []string{"2"},
+		Principal: "userid:foo",
+		Reason:    ReasonExplicitDeny,
+	}, decision)
+
+	// Implicitly denied.
+	decision = doorman.IsAllowed("https://sample.yaml", &Request{
+		Principals: Principals{"userid:foo"},
+		Action:     "delete",
+		Resource:   "server.org/blocklist:onecrl",
+	})
+	assert.Equal(t, &Decision{
+		Allowed:  false,
+		Policies: []string{},
+		Reason:   ReasonNoMatch,
+	}, decision)
 }
 
 func TestExpandPrincipals(t *testing.T) {
@@ -225,10 +282,9 @@ func TestExpandPrincipals(t *testing.T) {
 	assert.Equal(t, principals, Principals{"userid:maria", "tag:admins"})
 }
 
-func TestDoormanAllowed(t *testing.T) {
-	doorman := sampleDoorman()
-
-	for _, request := range []*Request{
+// sampleAllowedRequests are allowed by the sample policies.
+func sampleAllowedRequests() []*Request {
+	return []*Request{
 		// Policy #1
 		{
 			Principals: []string{"userid:foo"},
@@ -280,15 +336,20 @@ func TestDoormanAllowed(t *testing.T) {
 				"domain": "kinto.mozilla.org",
 			},
 		},
-	} {
-		assert.Equal(t, true, doorman.IsAllowed("https://sample.yaml", request))
 	}
 }
 
-func TestDoormanNotAllowed(t *testing.T) {
+func TestDoormanAllowed(t *testing.T) {
 	doorman := sampleDoorman()
 
-	for _, request := range []*Request{
+	for _, request := range sampleAllowedRequests() {
+		assert.Equal(t, true, doorman.IsAllowed("https://sample.yaml", request).Allowed)
+	}
+}
+
+// sampleNotAllowedRequests are denied by the sample policies.
+func sampleNotAllowedRequests() []*Request {
+	return []*Request{
 		// Policy #1
 		{
 			Principals: []string{"userid:foo"},
@@ -336,10 +397,14 @@ func TestDoormanNotAllowed(t *testing.T) {
 		{
 			Context: Context{},
 		},
-	} {
-		// Force context value like in handler.
-		request.Context["_principals"] = request.Principals
-		assert.Equal(t, false, doorman.IsAllowed("https://sample.yaml", request))
+	}
+}
+
+func TestDoormanNotAllowed(t *testing.T) {
+	doorman := sampleDoorman()
+
+	for _, request := range sampleNotAllowedRequests() {
+		assert.Equal(t, false, doorman.IsAllowed("https://sample.yaml", request).Allowed)
 	}
 }
 
@@ -347,10 +412,7 @@ func TestDoormanAuditLogger(t *testing.T) {
 	doorman := sampleDoorman()
 
 	var buf bytes.Buffer
-	doorman.auditLogger().logger.Out = &buf
-	defer func() {
-		doorman.auditLogger().logger.Out = os.Stdout
-	}()
+	doorman.SetAuditSinks(NewMozLogAuditSink(&buf))
 
 	// Logs when service is bad.
 	doorman.IsAllowed("bad service", &Request{})
@@ -365,8 +427,7 @@ func TestDoormanAuditLogger(t *testing.T) {
 		Action:     "any",
 		Resource:   "any",
 		Context: Context{
-			"planet":      "mars",
-			"_principals": Principals{"userid:any"},
+			"planet": "mars",
 		},
 	})
 	assert.Contains(t, buf.String(), "\"allowed\":false")
@@ -380,4 +441,34 @@ func TestDoormanAuditLogger(t *testing.T) {
 	})
 	assert.Contains(t, buf.String(), "\"allowed\":true")
 	assert.Contains(t, buf.String(), "\"policies\":[\"1\"]")
+
+	// Logs metadata, which cannot be overridden by the context.
+	buf.Reset()
+	doorman.IsAllowed(service, &Request{
+		Principals: Principals{"userid:foo"},
+		Action:     "update",
+		Resource:   "server.org/blocklist:onecrl",
+		Context: Context{
+			"_principals": "userid:admin",
+			"_service":    42,
+			"remoteIP":    "10.0.0.1:4242",
+		},
+		Metadata: Metadata{
+			RemoteAddr: "10.0.0.1:4242",
+			RequestID:  "abc",
+			Subject:    "foo",
+		},
+	})
+	var entry struct {
+		Fields map[string]interface{}
+	}
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, []interface{}{"userid:foo"}, entry.Fields["principals"])
+	assert.Equal(t, service, entry.Fields["service"])
+	assert.Equal(t, "10.0.0.1:4242", entry.Fields["remoteIP"])
+	assert.Equal(t, "abc", entry.Fields["requestID"])
+	assert.Equal(t, "foo", entry.Fields["subject"])
+	assert.Equal(t, "userid:admin", entry.Fields["context"].(map[string]interface{})["_principals"])
+	// The remote address is not logged twice.
+	assert.NotContains(t, entry.Fields["context"], "remoteIP")
 }
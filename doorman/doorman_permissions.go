@@ -0,0 +1,111 @@
+package doorman
+
+import (
+	"strings"
+
+	"github.com/ory/ladon"
+	log "github.com/sirupsen/logrus"
+)
+
+// Permission is an action granted on a resource.
+type Permission struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+	// Pattern is true when the action or the resource is a regular expression
+	// that could not be enumerated.
+	Pattern bool `json:"pattern"`
+}
+
+// Permissions returns the actions granted to the request principals by the
+// service policies. If the request resource is specified, only the permissions
+// on this resource are returned. The request action is ignored.
+//
+// Concrete actions and resources are checked like authorization requests (ie.
+// including deny policies, the combining algorithm and the attributes of the
+// resource). Regular expressions are returned as is.
+func (doorman *LadonDoorman) Permissions(service string, request *Request) []Permission {
+	permissions := []Permission{}
+
+	s := doorman.snapshot()
+	c, ok := s.services[service]
+	if !ok {
+		return permissions
+	}
+
+	r := ladonRequest(request)
+
+	seen := map[Permission]bool{}
+	for _, policy := range s.policies[service] {
+		if !policy.AllowAccess() {
+			continue
+		}
+		principal, err := matchPrincipals(policy, request.Principals, r)
+		if err != nil {
+			log.Warningf("Could not match policy %q: %s", policy.GetID(), err)
+			continue
+		}
+		if principal == "" {
+			continue
+		}
+
+		resources := policy.GetResources()
+		if request.Resource != "" {
+			// With hierarchical resources, policies on ancestors apply too.
+			if !matchesLevels(policy, request.Resource, c.ResourceSeparator) {
+				continue
+			}
+			resources = []string{request.Resource}
+		}
+
+		for _, action := range policy.GetActions() {
+			for _, resource := range resources {
+				permission := Permission{
+					Action:   action,
+					Resource: resource,
+					Pattern:  isPattern(policy, action) || isPattern(policy, resource),
+				}
+				if seen[permission] {
+					continue
+				}
+				if !permission.Pattern {
+					// Check the concrete permission on its own request, to leave the
+					// one matched against the next policies untouched.
+					candidate := *request
+					candidate.Action = action
+					candidate.Resource = resource
+					cr := ladonRequest(&candidate)
+					if err := s.attributes[service].enrich(&candidate, cr.Context); err != nil {
+						log.Debugf("Could not obtain attributes: %s", err)
+						continue
+					}
+					if !s.decide(service, request.Principals, cr).Allowed {
+						continue
+					}
+				}
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions
+}
+
+// matchesLevels returns true if one of the policy resources matches the resource
+// or, if the separator is not empty, one of its ancestors.
+func matchesLevels(policy ladon.Policy, resource string, separator string) bool {
+	levels := []string{resource}
+	if separator != "" {
+		levels = resourceLevels(resource, separator)
+	}
+	for _, level := range levels {
+		if ok, err := ladon.DefaultMatcher.Matches(policy, policy.GetResources(), level); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isPattern returns true if the policy value contains a regular expression.
+func isPattern(policy ladon.Policy, value string) bool {
+	return strings.Contains(value, string(policy.GetStartDelimiter()))
+}
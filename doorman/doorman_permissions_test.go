@@ -0,0 +1,108 @@
+package doorman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissions(t *testing.T) {
+	doorman := sampleDoorman()
+	service := "https://sample.yaml"
+
+	// Unknown service.
+	permissions := doorman.Permissions("https://bad.service", &Request{
+		Principals: Principals{"userid:foo"},
+	})
+	assert.Equal(t, []Permission{}, permissions)
+
+	// Regular expressions are returned as patterns.
+	permissions = doorman.Permissions(service, &Request{
+		Principals: Principals{"userid:foo"},
+	})
+	assert.Equal(t, []Permission{
+		{Action: "update", Resource: "<.*>", Pattern: true},
+	}, permissions)
+
+	// Conditions are evaluated against the context.
+	permissions = doorman.Permissions(service, &Request{
+		Principals: Principals{"userid:foo"},
+		Context: Context{
+			"ip": "127.0.0.1",
+		},
+	})
+	assert.Equal(t, []Permission{
+		{Action: "update", Resource: "<.*>", Pattern: true},
+		{Action: "read", Resource: "<.*>", Pattern: true},
+	}, permissions)
+
+	// Permissions on a specific resource are checked.
+	permissions = doorman.Permissions(service, &Request{
+		Principals: Principals{"userid:foo"},
+		Resource:   "server.org/blocklist:onecrl",
+	})
+	assert.Equal(t, []Permission{
+		{Action: "update", Resource: "server.org/blocklist:onecrl"},
+	}, permissions)
+
+	// Explicit denies are taken into account.
+	permissions = doorman.Permissions(service, &Request{
+		Principals: Principals{"userid:foo"},
+		Resource:   "server.org/blocklist:onecrl",
+		Context: Context{
+			"planet": "mars",
+		},
+	})
+	assert.Equal(t, []Permission{}, permissions)
+
+	// Concrete permissions are returned as is.
+	permissions = doorman.Permissions(service, &Request{
+		Principals: Principals{"role:editor"},
+	})
+	assert.Equal(t, []Permission{
+		{Action: "update", Resource: "pto"},
+	}, permissions)
+}
+
+func TestPermissionsRequestUnchanged(t *testing.T) {
+	doorman := NewDefaultLadon()
+	err := doorman.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "read",
+					Principals: Principals{"userid:foo"},
+					Actions:    []string{"read"},
+					Resources:  []string{"doc:1"},
+					Effect:     "allow",
+				},
+				Policy{
+					ID:         "write",
+					Principals: Principals{"userid:foo"},
+					Actions:    []string{"write"},
+					Resources:  []string{"<doc:.*>"},
+					Conditions: Conditions{
+						"notfirst": Condition{
+							Type:    "ExpressionCondition",
+							Options: map[string]interface{}{"expression": "resource != \"doc:1\""},
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	// The second policy is matched against the listing request, not the
+	// permission checked for the first one.
+	permissions := doorman.Permissions("a", &Request{
+		Principals: Principals{"userid:foo"},
+	})
+	assert.Equal(t, []Permission{
+		{Action: "read", Resource: "doc:1"},
+		{Action: "write", Resource: "<doc:.*>", Pattern: true},
+	}, permissions)
+}
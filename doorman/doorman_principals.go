@@ -0,0 +1,186 @@
+package doorman
+
+import (
+	"strings"
+
+	"github.com/ory/ladon"
+	log "github.com/sirupsen/logrus"
+)
+
+// AllowedPrincipals returns the principals that are allowed to perform the request
+// action on the request resource, with the request context and the attributes of
+// the resource. The request principals are ignored.
+//
+// The principals are returned as written in the policies (ie. including regular
+// expressions), without the ones that are explicitly denied or excluded. The members
+// of tags (including nested tags) and the principals bound to roles are listed after
+// them. The conditions that depend on the principals (eg. relations) are not evaluated,
+// except the exclusions.
+func (doorman *LadonDoorman) AllowedPrincipals(service string, request *Request) Principals {
+	principals := Principals{}
+
+	s := doorman.snapshot()
+	c, ok := s.services[service]
+	if !ok {
+		return principals
+	}
+
+	r := ladonRequest(request)
+	if err := s.attributes[service].enrich(request, r.Context); err != nil {
+		log.Warningf("Could not obtain attributes: %s", err)
+		return principals
+	}
+
+	// Policies that apply to the action and resource, in the order of the configuration.
+	// With hierarchical resources, the policies of every level apply, the most specific
+	// ones first.
+	levels := []string{r.Resource}
+	if c.ResourceSeparator != "" {
+		levels = resourceLevels(r.Resource, c.ResourceSeparator)
+	}
+	matcher := ladon.DefaultMatcher
+	matching := ladon.Policies{}
+	matched := map[string]bool{}
+	for _, level := range levels {
+		for _, policy := range s.policies[service] {
+			if matched[policy.GetID()] {
+				continue
+			}
+			if ok, err := matcher.Matches(policy, policy.GetActions(), r.Action); err != nil || !ok {
+				continue
+			}
+			if ok, err := matcher.Matches(policy, policy.GetResources(), level); err != nil || !ok {
+				continue
+			}
+			matched[policy.GetID()] = true
+			matching = append(matching, policy)
+		}
+	}
+
+	// denied returns true if one of the deny policies applies to the principals
+	// allowed by the policy at the specified position.
+	denied := func(position int, candidates Principals) bool {
+		if c.CombiningAlgorithm == PermitOverrides {
+			return false
+		}
+		for i, policy := range matching {
+			if c.CombiningAlgorithm == FirstApplicable && i > position {
+				break
+			}
+			if policy.AllowAccess() {
+				continue
+			}
+			if principal, _ := matchPrincipals(policy, candidates, r); principal != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	seen := map[string]bool{}
+
+	// include adds the principal allowed by the policy at the specified position, followed
+	// by the members of tags or the principals bound to roles.
+	var include func(position int, principal string, via Principals)
+	include = func(position int, principal string, via Principals) {
+		candidates := append(Principals{principal}, via...)
+		if seen[principal] || excluded(matching[position], candidates, r) || denied(position, candidates) {
+			return
+		}
+		seen[principal] = true
+		principals = append(principals, principal)
+
+		switch {
+		case strings.HasPrefix(principal, tagPrefix):
+			for _, member := range s.tags[service].members(strings.TrimPrefix(principal, tagPrefix)) {
+				include(position, member, candidates)
+			}
+		case strings.HasPrefix(principal, rolePrefix):
+			for _, bound := range s.roles[service].principals(strings.TrimPrefix(principal, rolePrefix), r.Resource) {
+				include(position, bound, candidates)
+			}
+		}
+	}
+
+	for i, policy := range matching {
+		if !policy.AllowAccess() {
+			continue
+		}
+		// The subjects may be regular expressions, they are thus not matched against
+		// the conditions that depend on the principals.
+		r.Subject = ""
+		if !fulfills(contextConditions(policy.GetConditions()), &evaluation{request: r, principals: Principals{}}) {
+			continue
+		}
+		for _, subject := range policySubjects(policy, r) {
+			include(i, subject, nil)
+		}
+	}
+	return principals
+}
+
+// excluded returns true if one of the principals is excluded from the policy (see
+// ExceptPrincipalsCondition).
+func excluded(policy ladon.Policy, principals Principals, r *ladon.Request) bool {
+	for _, condition := range policy.GetConditions() {
+		if c, ok := condition.(*ExceptPrincipalsCondition); ok && !c.fulfillsRequest(nil, &evaluation{request: r, principals: principals}) {
+			return true
+		}
+	}
+	return false
+}
+
+// policySubjects returns the subjects of the policy or, if it has MatchPrincipalsCondition,
+// the principals of the request context that match them.
+func policySubjects(policy ladon.Policy, r *ladon.Request) Principals {
+	subjects := Principals(policy.GetSubjects())
+	for field, condition := range policy.GetConditions() {
+		if _, ok := condition.(*MatchPrincipalsCondition); !ok {
+			continue
+		}
+		values := []string{}
+		switch v := r.Context[field].(type) {
+		case string:
+			values = []string{v}
+		case []string:
+			values = v
+		}
+		owners := Principals{}
+		for _, value := range values {
+			if ok, err := ladon.DefaultMatcher.Matches(policy, subjects, value); err == nil && ok {
+				owners = append(owners, value)
+			}
+		}
+		subjects = owners
+	}
+	return subjects
+}
+
+// contextConditions returns the conditions that do not depend on the principals.
+func contextConditions(conditions ladon.Conditions) ladon.Conditions {
+	filtered := ladon.Conditions{}
+	for field, condition := range conditions {
+		if !dependsOnPrincipals(condition) {
+			filtered[field] = condition
+		}
+	}
+	return filtered
+}
+
+// dependsOnPrincipals returns true if the condition is evaluated against the subject
+// or the principals of the request.
+func dependsOnPrincipals(condition ladon.Condition) bool {
+	switch c := condition.(type) {
+	case *MatchPrincipalsCondition, *ladon.EqualsSubjectCondition, *ExceptPrincipalsCondition, *RelationCondition, *RateLimitCondition:
+		return true
+	case *ExpressionCondition:
+		return c.principals
+	case *AllOfCondition:
+		return len(contextConditions(c.Conditions)) < len(c.Conditions)
+	case *AnyOfCondition:
+		return len(contextConditions(c.Conditions)) < len(c.Conditions)
+	case *NotCondition:
+		return len(contextConditions(c.Conditions)) < len(c.Conditions)
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package doorman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowedPrincipals(t *testing.T) {
+	doorman := sampleDoorman()
+	service := "https://sample.yaml"
+
+	// Unknown service.
+	principals := doorman.AllowedPrincipals("https://bad.service", &Request{
+		Action:   "update",
+		Resource: "server.org/blocklist:onecrl",
+	})
+	assert.Equal(t, Principals{}, principals)
+
+	// Tags are expanded.
+	principals = doorman.AllowedPrincipals(service, &Request{
+		Action:   "update",
+		Resource: "server.org/blocklist:onecrl",
+	})
+	assert.Equal(t, Principals{"userid:foo", "tag:admins", "userid:maria"}, principals)
+
+	// Specific resource.
+	principals = doorman.AllowedPrincipals(service, &Request{
+		Action:   "update",
+		Resource: "pto",
+	})
+	assert.Equal(t, Principals{"userid:foo", "tag:admins", "userid:maria", "role:editor"}, principals)
+
+	// Conditions are evaluated against the context.
+	principals = doorman.AllowedPrincipals(service, &Request{
+		Action:   "read",
+		Resource: "server.org/blocklist:onecrl",
+		Context: Context{
+			"ip": "127.0.0.1",
+		},
+	})
+	assert.Equal(t, Principals{"<.*>"}, principals)
+
+	// Explicit denies are subtracted.
+	principals = doorman.AllowedPrincipals(service, &Request{
+		Action:   "update",
+		Resource: "server.org/blocklist:onecrl",
+		Context: Context{
+			"planet": "mars",
+		},
+	})
+	assert.Equal(t, Principals{}, principals)
+}
+
+func TestAllowedPrincipalsCombiningAlgorithms(t *testing.T) {
+	allow := Policy{
+		ID:         "allow",
+		Principals: Principals{"userid:alice", "userid:bob"},
+		Actions:    []string{"delete"},
+		Resources:  []string{"article"},
+		Effect:     "allow",
+	}
+	deny := Policy{
+		ID:         "deny",
+		Principals: Principals{"userid:bob"},
+		Actions:    []string{"<.*>"},
+		Resources:  []string{"<.*>"},
+		Effect:     "deny",
+	}
+	request := &Request{
+		Action:   "delete",
+		Resource: "article",
+	}
+
+	for _, test := range []struct {
+		algorithm string
+		policies  Policies
+		expected  Principals
+	}{
+		{DenyOverrides, Policies{allow, deny}, Principals{"userid:alice"}},
+		{PermitOverrides, Policies{deny, allow}, Principals{"userid:alice", "userid:bob"}},
+		{FirstApplicable, Policies{allow, deny}, Principals{"userid:alice", "userid:bob"}},
+		{FirstApplicable, Policies{deny, allow}, Principals{"userid:alice"}},
+	} {
+		doorman := NewDefaultLadon()
+		err := doorman.LoadPolicies(ServicesConfig{
+			ServiceConfig{
+				Service:            "a",
+				CombiningAlgorithm: test.algorithm,
+				Policies:           test.policies,
+			},
+		})
+		require.Nil(t, err)
+		assert.Equal(t, test.expected, doorman.AllowedPrincipals("a", request), test.algorithm)
+	}
+}
+
+func TestAllowedPrincipalsPatterns(t *testing.T) {
+	doorman := NewDefaultLadon()
+	err := doorman.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "staff",
+					Principals: Principals{"<userid:.*>", "email:<.*@mozilla\\.com>", "userid:bob"},
+					Actions:    []string{"read"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"env": Condition{
+							Type:    "StringEqualCondition",
+							Options: map[string]interface{}{"equals": "prod"},
+						},
+					},
+					Effect: "allow",
+				},
+				Policy{
+					ID:         "owner",
+					Principals: Principals{"<userid:.*>"},
+					Actions:    []string{"read"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"owner": Condition{Type: "MatchPrincipalsCondition"},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	// Regular expressions are returned as is.
+	principals := doorman.AllowedPrincipals("a", &Request{
+		Action:  "read",
+		Context: Context{"env": "prod"},
+	})
+	assert.Equal(t, Principals{"<userid:.*>", "email:<.*@mozilla\\.com>", "userid:bob"}, principals)
+
+	// Conditions on the context are still evaluated.
+	principals = doorman.AllowedPrincipals("a", &Request{
+		Action:  "read",
+		Context: Context{"env": "stage"},
+	})
+	assert.Equal(t, Principals{}, principals)
+
+	// Owners given in the context.
+	principals = doorman.AllowedPrincipals("a", &Request{
+		Action:  "read",
+		Context: Context{"env": "stage", "owner": "userid:alice"},
+	})
+	assert.Equal(t, Principals{"userid:alice"}, principals)
+}
@@ -0,0 +1,330 @@
+package doorman
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxRelationDepth limits the nesting of usersets and rewrites when checking a relation.
+const maxRelationDepth = 16
+
+// Tuple is a relationship between an object and a subject (eg. "document:42#editor@userid:alice").
+// The subject is either a principal or a userset (eg. "folder:1#viewer").
+type Tuple struct {
+	Object   string
+	Relation string
+	Subject  string
+}
+
+// ParseTuple parses a tuple from its "object#relation@subject" notation.
+func ParseTuple(s string) (Tuple, error) {
+	at := strings.Index(s, "@")
+	if at < 0 {
+		return Tuple{}, fmt.Errorf("invalid tuple %q", s)
+	}
+	hash := strings.Index(s[:at], "#")
+	if hash < 0 {
+		return Tuple{}, fmt.Errorf("invalid tuple %q", s)
+	}
+	t := Tuple{
+		Object:   s[:hash],
+		Relation: s[hash+1 : at],
+		Subject:  s[at+1:],
+	}
+	if t.Object == "" || t.Relation == "" || t.Subject == "" {
+		return Tuple{}, fmt.Errorf("invalid tuple %q", s)
+	}
+	return t, nil
+}
+
+func (t Tuple) String() string {
+	return fmt.Sprintf("%s#%s@%s", t.Object, t.Relation, t.Subject)
+}
+
+// Relations are the userset rewrites of each object type. The rewrites of a relation
+// are either another relation of the same object (eg. editors are viewers: "editor"),
+// or a relation of the objects related to it (eg. "parent->viewer").
+type Relations map[string]map[string][]string
+
+// TupleStore holds the relationship tuples of the services.
+type TupleStore interface {
+	// Update removes and adds the tuples atomically (ie. all the changes are applied
+	// or none).
+	Update(service string, deletes []Tuple, writes []Tuple) error
+	// Subjects returns the subjects that have the relation with the object.
+	Subjects(service string, object string, relation string) ([]string, error)
+}
+
+// MemoryTupleStore keeps the tuples in memory.
+type MemoryTupleStore struct {
+	mu sync.RWMutex
+	// tuples are the subjects by "object#relation", for each service.
+	tuples map[string]map[string]map[string]bool
+}
+
+// NewMemoryTupleStore instantiates an empty store.
+func NewMemoryTupleStore() *MemoryTupleStore {
+	return &MemoryTupleStore{
+		tuples: map[string]map[string]map[string]bool{},
+	}
+}
+
+// Write adds the tuples.
+func (m *MemoryTupleStore) Write(service string, tuples []Tuple) error {
+	return m.Update(service, nil, tuples)
+}
+
+// Delete removes the tuples.
+func (m *MemoryTupleStore) Delete(service string, tuples []Tuple) error {
+	return m.Update(service, tuples, nil)
+}
+
+// Update removes and adds the tuples, the deletes first.
+func (m *MemoryTupleStore) Update(service string, deletes []Tuple, writes []Tuple) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range deletes {
+		key := t.Object + "#" + t.Relation
+		delete(m.tuples[service][key], t.Subject)
+		if len(m.tuples[service][key]) == 0 {
+			delete(m.tuples[service], key)
+		}
+	}
+	if len(writes) > 0 && m.tuples[service] == nil {
+		m.tuples[service] = map[string]map[string]bool{}
+	}
+	for _, t := range writes {
+		key := t.Object + "#" + t.Relation
+		if m.tuples[service][key] == nil {
+			m.tuples[service][key] = map[string]bool{}
+		}
+		m.tuples[service][key][t.Subject] = true
+	}
+	return nil
+}
+
+// Subjects returns the subjects that have the relation with the object, sorted.
+func (m *MemoryTupleStore) Subjects(service string, object string, relation string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	subjects := []string{}
+	for subject := range m.tuples[service][object+"#"+relation] {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects, nil
+}
+
+// dump returns the tuples of each service, sorted.
+func (m *MemoryTupleStore) dump() map[string][]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	all := map[string][]string{}
+	for service, keys := range m.tuples {
+		for key, subjects := range keys {
+			for subject := range subjects {
+				all[service] = append(all[service], key+"@"+subject)
+			}
+		}
+		sort.Strings(all[service])
+	}
+	return all
+}
+
+// clone returns a copy of the store.
+func (m *MemoryTupleStore) clone() *MemoryTupleStore {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := NewMemoryTupleStore()
+	for service, keys := range m.tuples {
+		c.tuples[service] = map[string]map[string]bool{}
+		for key, subjects := range keys {
+			c.tuples[service][key] = map[string]bool{}
+			for subject := range subjects {
+				c.tuples[service][key][subject] = true
+			}
+		}
+	}
+	return c
+}
+
+// FileTupleStore keeps the tuples in memory and saves them in a local JSON file
+// after each change.
+type FileTupleStore struct {
+	*MemoryTupleStore
+	filename string
+	// mu serializes the changes and the writes of the file.
+	mu sync.Mutex
+}
+
+// NewFileTupleStore instantiates a store with the tuples of the file, if it exists.
+func NewFileTupleStore(filename string) (*FileTupleStore, error) {
+	f := &FileTupleStore{
+		MemoryTupleStore: NewMemoryTupleStore(),
+		filename:         filename,
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	all := map[string][]string{}
+	if err := json.Unmarshal(content, &all); err != nil {
+		return nil, fmt.Errorf("invalid tuples file %q: %s", filename, err)
+	}
+	for service, strs := range all {
+		tuples := []Tuple{}
+		for _, s := range strs {
+			t, err := ParseTuple(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s in %q", err, filename)
+			}
+			tuples = append(tuples, t)
+		}
+		f.MemoryTupleStore.Write(service, tuples)
+	}
+	return f, nil
+}
+
+// Write adds the tuples and saves the file.
+func (f *FileTupleStore) Write(service string, tuples []Tuple) error {
+	return f.Update(service, nil, tuples)
+}
+
+// Delete removes the tuples and saves the file.
+func (f *FileTupleStore) Delete(service string, tuples []Tuple) error {
+	return f.Update(service, tuples, nil)
+}
+
+// Update removes and adds the tuples, and saves the file. The tuples in memory are
+// left untouched if the file could not be saved.
+func (f *FileTupleStore) Update(service string, deletes []Tuple, writes []Tuple) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	next := f.MemoryTupleStore.clone()
+	next.Update(service, deletes, writes)
+	if err := f.save(next.dump()); err != nil {
+		return err
+	}
+	return f.MemoryTupleStore.Update(service, deletes, writes)
+}
+
+// save replaces the file atomically.
+func (f *FileTupleStore) save(all map[string][]string) error {
+	content, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.filename), filepath.Base(f.filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.filename)
+}
+
+// relationGraph checks the relations of a service, using its tuples and rewrites.
+type relationGraph struct {
+	service  string
+	store    TupleStore
+	rewrites Relations
+}
+
+// newRelationGraph validates the rewrites of the service.
+func newRelationGraph(service string, store TupleStore, rewrites Relations) (*relationGraph, error) {
+	for objectType, relations := range rewrites {
+		for relation, rules := range relations {
+			for _, rule := range rules {
+				parts := strings.Split(rule, "->")
+				if len(parts) > 2 || parts[0] == "" || parts[len(parts)-1] == "" {
+					return nil, fmt.Errorf("invalid rewrite %q of relation %q in %q", rule, relation, objectType)
+				}
+			}
+		}
+	}
+	return &relationGraph{
+		service:  service,
+		store:    store,
+		rewrites: rewrites,
+	}, nil
+}
+
+// check returns true if one of the principals has the relation with the object.
+func (g *relationGraph) check(object string, relation string, principals Principals) (bool, error) {
+	wanted := map[string]bool{}
+	for _, principal := range principals {
+		wanted[principal] = true
+	}
+	return g.lookup(object, relation, wanted, map[string]bool{}, 0)
+}
+
+func (g *relationGraph) lookup(object string, relation string, principals map[string]bool, visited map[string]bool, depth int) (bool, error) {
+	key := object + "#" + relation
+	if visited[key] {
+		return false, nil
+	}
+	if depth > maxRelationDepth {
+		return false, fmt.Errorf("relation %q is nested too deeply", key)
+	}
+	visited[key] = true
+
+	// Direct tuples, with principals or usersets.
+	subjects, err := g.store.Subjects(g.service, object, relation)
+	if err != nil {
+		return false, err
+	}
+	for _, subject := range subjects {
+		if principals[subject] {
+			return true, nil
+		}
+		if i := strings.Index(subject, "#"); i > 0 {
+			if ok, err := g.lookup(subject[:i], subject[i+1:], principals, visited, depth+1); ok || err != nil {
+				return ok, err
+			}
+		}
+	}
+
+	// Rewrites of the object type.
+	objectType := strings.SplitN(object, ":", 2)[0]
+	for _, rule := range g.rewrites[objectType][relation] {
+		parts := strings.Split(rule, "->")
+		if len(parts) == 1 {
+			if ok, err := g.lookup(object, rule, principals, visited, depth+1); ok || err != nil {
+				return ok, err
+			}
+			continue
+		}
+		// The relation on the related objects (eg. the viewers of the parent folder).
+		related, err := g.store.Subjects(g.service, object, parts[0])
+		if err != nil {
+			return false, err
+		}
+		for _, other := range related {
+			if ok, err := g.lookup(other, parts[1], principals, visited, depth+1); ok || err != nil {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,284 @@
+package doorman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTuple(t *testing.T) {
+	tuple, err := ParseTuple("document:42#editor@userid:alice")
+	require.Nil(t, err)
+	assert.Equal(t, Tuple{Object: "document:42", Relation: "editor", Subject: "userid:alice"}, tuple)
+	assert.Equal(t, "document:42#editor@userid:alice", tuple.String())
+
+	tuple, err = ParseTuple("document:42#viewer@folder:1#viewer")
+	require.Nil(t, err)
+	assert.Equal(t, "folder:1#viewer", tuple.Subject)
+
+	for _, s := range []string{"", "document:42", "document:42#editor", "document:42@userid:alice", "#editor@userid:alice", "document:42#@userid:alice"} {
+		_, err := ParseTuple(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func mustTuples(t *testing.T, strs ...string) []Tuple {
+	tuples := []Tuple{}
+	for _, s := range strs {
+		tuple, err := ParseTuple(s)
+		require.Nil(t, err)
+		tuples = append(tuples, tuple)
+	}
+	return tuples
+}
+
+func relationsDoorman(t *testing.T) *LadonDoorman {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Relations: Relations{
+				"document": {
+					"viewer": []string{"editor", "parent->viewer"},
+				},
+				"folder": {
+					"viewer": []string{"owner"},
+				},
+			},
+			Policies: Policies{
+				Policy{
+					ID:         "viewers",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"read"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"document": Condition{
+							Type: "RelationCondition",
+							Options: map[string]interface{}{
+								"relation": "viewer",
+							},
+						},
+					},
+					Effect: "allow",
+				},
+				Policy{
+					ID:         "editors",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"write"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"any": Condition{
+							Type: "AnyOfCondition",
+							Options: map[string]interface{}{
+								"conditions": map[string]interface{}{
+									"document": map[string]interface{}{
+										"type": "RelationCondition",
+										"options": map[string]interface{}{
+											"relation": "editor",
+										},
+									},
+								},
+							},
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+	err = d.UpdateTuples("a", nil, mustTuples(t,
+		"document:42#editor@userid:alice",
+		"document:42#parent@folder:1",
+		"document:43#viewer@group:team#member",
+		"group:team#member@userid:carol",
+		"folder:1#owner@userid:bob",
+	))
+	require.Nil(t, err)
+	return d
+}
+
+func TestRelationCondition(t *testing.T) {
+	d := relationsDoorman(t)
+
+	var cases = []struct {
+		principal string
+		action    string
+		document  interface{}
+		allowed   bool
+	}{
+		// Direct tuple.
+		{"userid:alice", "write", "document:42", true},
+		// Editors are viewers.
+		{"userid:alice", "read", "document:42", true},
+		// Viewers of the parent folder, owners of the folder.
+		{"userid:bob", "read", "document:42", true},
+		{"userid:bob", "write", "document:42", false},
+		// Userset.
+		{"userid:carol", "read", "document:43", true},
+		{"userid:carol", "read", "document:42", false},
+		{"userid:alice", "read", "document:43", false},
+		// Resource is used when the context field is missing.
+		{"userid:alice", "read", nil, true},
+		// Not a string.
+		{"userid:alice", "read", 42, false},
+	}
+	for _, c := range cases {
+		context := Context{}
+		if c.document != nil {
+			context["document"] = c.document
+		}
+		decision := d.IsAllowed("a", &Request{
+			Principals: Principals{c.principal},
+			Action:     c.action,
+			Resource:   "document:42",
+			Context:    context,
+		})
+		assert.Equal(t, c.allowed, decision.Allowed, "%s %s %v", c.principal, c.action, c.document)
+	}
+
+	// Deleted tuples.
+	require.Nil(t, d.UpdateTuples("a", mustTuples(t, "document:42#parent@folder:1"), nil))
+	decision := d.IsAllowed("a", &Request{
+		Principals: Principals{"userid:bob"},
+		Action:     "read",
+		Resource:   "document:42",
+	})
+	assert.False(t, decision.Allowed)
+}
+
+func TestRelationCycles(t *testing.T) {
+	store := NewMemoryTupleStore()
+	graph, err := newRelationGraph("a", store, Relations{
+		"document": {
+			"viewer": []string{"editor"},
+			"editor": []string{"viewer"},
+		},
+	})
+	require.Nil(t, err)
+	store.Write("a", mustTuples(t, "document:1#viewer@document:1#viewer"))
+
+	ok, err := graph.check("document:1", "viewer", Principals{"userid:alice"})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestTuplesUnknownService(t *testing.T) {
+	d := relationsDoorman(t)
+	assert.NotNil(t, d.UpdateTuples("b", nil, mustTuples(t, "document:42#editor@userid:alice")))
+}
+
+func TestBadRelations(t *testing.T) {
+	for _, rule := range []string{"", "parent->", "->viewer", "a->b->c"} {
+		d := NewDefaultLadon()
+		err := d.LoadPolicies(ServicesConfig{
+			ServiceConfig{
+				Service: "a",
+				Relations: Relations{
+					"document": {"viewer": []string{rule}},
+				},
+			},
+		})
+		assert.Contains(t, err.Error(), "invalid rewrite", rule)
+	}
+
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					Conditions: Conditions{
+						"document": Condition{Type: "RelationCondition"},
+					},
+				},
+			},
+		},
+	})
+	assert.Contains(t, err.Error(), "missing relation in RelationCondition")
+}
+
+func TestFileTupleStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tuples")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tuples.json")
+
+	store, err := NewFileTupleStore(filename)
+	require.Nil(t, err)
+	require.Nil(t, store.Write("a", mustTuples(t, "document:42#editor@userid:alice", "document:42#editor@userid:bob")))
+	require.Nil(t, store.Delete("a", mustTuples(t, "document:42#editor@userid:bob")))
+
+	// Reloaded from file.
+	store, err = NewFileTupleStore(filename)
+	require.Nil(t, err)
+	subjects, err := store.Subjects("a", "document:42", "editor")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"userid:alice"}, subjects)
+
+	// Deletes and writes are applied together.
+	require.Nil(t, store.Update("a",
+		mustTuples(t, "document:42#editor@userid:alice"),
+		mustTuples(t, "document:42#editor@userid:carol"),
+	))
+	store, err = NewFileTupleStore(filename)
+	require.Nil(t, err)
+	subjects, err = store.Subjects("a", "document:42", "editor")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"userid:carol"}, subjects)
+
+	// Nothing is changed if the file cannot be saved.
+	unsaved, err := NewFileTupleStore(filepath.Join(dir, "missing", "tuples.json"))
+	require.Nil(t, err)
+	assert.NotNil(t, unsaved.Write("a", mustTuples(t, "document:42#editor@userid:alice")))
+	subjects, err = unsaved.Subjects("a", "document:42", "editor")
+	require.Nil(t, err)
+	assert.Equal(t, []string{}, subjects)
+
+	// Bad files.
+	ioutil.WriteFile(filename, []byte("{"), 0644)
+	_, err = NewFileTupleStore(filename)
+	assert.Contains(t, err.Error(), "invalid tuples file")
+
+	ioutil.WriteFile(filename, []byte(`{"a": ["document:42"]}`), 0644)
+	_, err = NewFileTupleStore(filename)
+	assert.Contains(t, err.Error(), "invalid tuple \"document:42\"")
+}
+
+func TestSetTupleStore(t *testing.T) {
+	store := NewMemoryTupleStore()
+	store.Write("a", mustTuples(t, "document:42#viewer@userid:alice"))
+
+	d := NewDefaultLadon()
+	d.SetTupleStore(store)
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "viewers",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"read"},
+					Resources:  []string{"<.*>"},
+					Conditions: Conditions{
+						"document": Condition{
+							Type:    "RelationCondition",
+							Options: map[string]interface{}{"relation": "viewer"},
+						},
+					},
+					Effect: "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+	assert.True(t, d.IsAllowed("a", &Request{
+		Principals: Principals{"userid:alice"},
+		Action:     "read",
+		Resource:   "document:42",
+	}).Allowed)
+}
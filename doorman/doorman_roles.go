@@ -0,0 +1,142 @@
+package doorman
+
+import (
+	"fmt"
+)
+
+// rolePrefix is the prefix of the principals that designate roles.
+const rolePrefix = "role:"
+
+// RoleBinding grants roles to principals, optionally on some resources only.
+type RoleBinding struct {
+	// Principals can be tags or regular expressions like in policies.
+	Principals Principals
+	Roles      []string
+	// Resources restricts the binding to these resources (optional).
+	Resources []string
+}
+
+// RoleBindings is a collection of role bindings.
+type RoleBindings []RoleBinding
+
+// ClientRoles restricts the roles that are provided by clients in the
+// "roles" field of the authorization requests context.
+type ClientRoles struct {
+	// Disabled ignores the roles provided by clients.
+	Disabled bool
+	// Allowed only accepts these roles from clients (optional).
+	Allowed []string
+}
+
+// roleIndex gives the roles of the principals. It is built once when the
+// policies are loaded.
+type roleIndex struct {
+	bindings []*roleBinding
+	client   ClientRoles
+	allowed  map[string]bool
+}
+
+type roleBinding struct {
+	binding    RoleBinding
+	principals *patterns
+	// resources is nil if the binding applies to any resource.
+	resources *patterns
+}
+
+func newRoleIndex(bindings RoleBindings, client ClientRoles) (*roleIndex, error) {
+	idx := &roleIndex{
+		client:  client,
+		allowed: map[string]bool{},
+	}
+	for _, role := range client.Allowed {
+		idx.allowed[role] = true
+	}
+	for i, binding := range bindings {
+		where := fmt.Sprintf("role binding #%d", i+1)
+		if len(binding.Principals) == 0 || len(binding.Roles) == 0 {
+			return nil, fmt.Errorf("missing principals or roles in %s", where)
+		}
+		b := &roleBinding{binding: binding}
+		var err error
+		if b.principals, err = newPatterns(binding.Principals, '<', '>', where); err != nil {
+			return nil, err
+		}
+		if len(binding.Resources) > 0 {
+			if b.resources, err = newPatterns(binding.Resources, '<', '>', where); err != nil {
+				return nil, err
+			}
+		}
+		idx.bindings = append(idx.bindings, b)
+	}
+	return idx, nil
+}
+
+// appliesTo returns true if the binding applies to the resource.
+func (b *roleBinding) appliesTo(resource string) bool {
+	return b.resources == nil || b.resources.matches(resource)
+}
+
+// roles returns the roles bound to the request principals on the request resource,
+// followed by the accepted roles provided by the client.
+func (idx *roleIndex) roles(request *Request) Principals {
+	result := Principals{}
+	seen := map[string]bool{}
+	add := func(role string) {
+		if !seen[role] {
+			seen[role] = true
+			result = append(result, role)
+		}
+	}
+
+	for _, b := range idx.bindings {
+		if !b.appliesTo(request.Resource) {
+			continue
+		}
+		for _, principal := range request.Principals {
+			if b.principals.matches(principal) {
+				for _, role := range b.binding.Roles {
+					add(rolePrefix + role)
+				}
+				break
+			}
+		}
+	}
+
+	if idx.client.Disabled {
+		return result
+	}
+	for _, role := range request.Roles() {
+		if len(idx.allowed) == 0 || idx.allowed[role[len(rolePrefix):]] {
+			add(role)
+		}
+	}
+	return result
+}
+
+// principals returns the principals bound to the role on the resource, as written
+// in the bindings.
+func (idx *roleIndex) principals(role string, resource string) Principals {
+	result := Principals{}
+	for _, b := range idx.bindings {
+		if !b.appliesTo(resource) {
+			continue
+		}
+		for _, r := range b.binding.Roles {
+			if r == role {
+				result = append(result, b.binding.Principals...)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Roles returns the roles of the request principals (ie. "role:" principals)
+// from the service role bindings and the roles provided in the request context.
+func (doorman *LadonDoorman) Roles(service string, request *Request) Principals {
+	roles, ok := doorman.snapshot().roles[service]
+	if !ok {
+		return request.Roles()
+	}
+	return roles.roles(request)
+}
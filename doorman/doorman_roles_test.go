@@ -0,0 +1,152 @@
+package doorman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Tags: Tags{
+				"admins": Principals{"userid:maria"},
+			},
+			RoleBindings: RoleBindings{
+				RoleBinding{
+					Principals: Principals{"tag:admins", "email:<.*@mozilla\\.com>"},
+					Roles:      []string{"editor"},
+				},
+				RoleBinding{
+					Principals: Principals{"userid:bob"},
+					Roles:      []string{"author", "reviewer"},
+					Resources:  []string{"article/<.*>"},
+				},
+			},
+		},
+		ServiceConfig{
+			Service: "b",
+			RoleBindings: RoleBindings{
+				RoleBinding{
+					Principals: Principals{"userid:bob"},
+					Roles:      []string{"author"},
+				},
+			},
+			ClientRoles: ClientRoles{
+				Allowed: []string{"viewer"},
+			},
+		},
+		ServiceConfig{
+			Service: "c",
+			ClientRoles: ClientRoles{
+				Disabled: true,
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	clientRoles := Context{
+		"roles": []interface{}{"viewer", "admin"},
+	}
+
+	// Bound to tags and patterns.
+	roles := d.Roles("a", &Request{Principals: Principals{"userid:maria", "tag:admins"}})
+	assert.Equal(t, Principals{"role:editor"}, roles)
+	roles = d.Roles("a", &Request{Principals: Principals{"email:ada@mozilla.com"}})
+	assert.Equal(t, Principals{"role:editor"}, roles)
+
+	// Scoped to resources.
+	roles = d.Roles("a", &Request{Principals: Principals{"userid:bob"}, Resource: "article/1"})
+	assert.Equal(t, Principals{"role:author", "role:reviewer"}, roles)
+	roles = d.Roles("a", &Request{Principals: Principals{"userid:bob"}, Resource: "comment/1"})
+	assert.Equal(t, Principals{}, roles)
+
+	// Client roles are accepted by default.
+	roles = d.Roles("a", &Request{Principals: Principals{"userid:bob"}, Context: clientRoles})
+	assert.Equal(t, Principals{"role:viewer", "role:admin"}, roles)
+
+	// Restricted client roles.
+	roles = d.Roles("b", &Request{Principals: Principals{"userid:bob"}, Context: clientRoles})
+	assert.Equal(t, Principals{"role:author", "role:viewer"}, roles)
+
+	// Disabled client roles.
+	roles = d.Roles("c", &Request{Principals: Principals{"userid:bob"}, Context: clientRoles})
+	assert.Equal(t, Principals{}, roles)
+
+	// Unknown service.
+	roles = d.Roles("unknown", &Request{Principals: Principals{"userid:bob"}, Context: clientRoles})
+	assert.Equal(t, Principals{"role:viewer", "role:admin"}, roles)
+}
+
+func TestBadRoleBindings(t *testing.T) {
+	d := NewDefaultLadon()
+
+	// Missing roles.
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Source:  "a.yaml",
+			Service: "a",
+			RoleBindings: RoleBindings{
+				RoleBinding{
+					Principals: Principals{"userid:bob"},
+				},
+			},
+		},
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "missing principals or roles in role binding #1 (source \"a.yaml\")", err.Error())
+
+	// Bad pattern.
+	err = d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			RoleBindings: RoleBindings{
+				RoleBinding{
+					Principals: Principals{"userid:<[a-z>"},
+					Roles:      []string{"author"},
+				},
+			},
+		},
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid pattern \"userid:<[a-z>\" in role binding #1")
+}
+
+func TestAllowedPrincipalsRoleBindings(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Tags: Tags{
+				"admins": Principals{"userid:maria"},
+			},
+			RoleBindings: RoleBindings{
+				RoleBinding{
+					Principals: Principals{"tag:admins"},
+					Roles:      []string{"editor"},
+				},
+				RoleBinding{
+					Principals: Principals{"userid:bob"},
+					Roles:      []string{"editor"},
+					Resources:  []string{"comment"},
+				},
+			},
+			Policies: Policies{
+				Policy{
+					ID:         "1",
+					Principals: Principals{"role:editor"},
+					Actions:    []string{"update"},
+					Resources:  []string{"<.*>"},
+					Effect:     "allow",
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	principals := d.AllowedPrincipals("a", &Request{Action: "update", Resource: "article"})
+	assert.Equal(t, Principals{"role:editor", "tag:admins", "userid:maria"}, principals)
+}
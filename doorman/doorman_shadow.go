@@ -0,0 +1,78 @@
+package doorman
+
+import (
+	"sync"
+)
+
+// Policy modes.
+const (
+	// EnforceMode policies decide (default).
+	EnforceMode = "enforce"
+	// ShadowMode policies are evaluated but never change the decisions.
+	ShadowMode = "shadow"
+)
+
+// ShadowStats count the requests whose decision would change if the shadow
+// policies of a service were enforced.
+type ShadowStats struct {
+	// Divergences is the total of requests.
+	Divergences int `json:"divergences"`
+	// Granted is the number of denied requests that would be allowed.
+	Granted int `json:"granted"`
+	// Revoked is the number of allowed requests that would be denied.
+	Revoked int `json:"revoked"`
+	// Policies count the divergences by deciding policy.
+	Policies map[string]int `json:"policies"`
+}
+
+// shadowCounters hold the stats of each service.
+type shadowCounters struct {
+	mu    sync.Mutex
+	stats map[string]*ShadowStats
+}
+
+// add counts a divergence, given the shadow decision.
+func (c *shadowCounters) add(service string, shadow *Decision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stats == nil {
+		c.stats = map[string]*ShadowStats{}
+	}
+	stats, ok := c.stats[service]
+	if !ok {
+		stats = &ShadowStats{Policies: map[string]int{}}
+		c.stats[service] = stats
+	}
+	stats.Divergences++
+	if shadow.Allowed {
+		stats.Granted++
+	} else {
+		stats.Revoked++
+	}
+	for _, id := range shadow.Policies {
+		stats.Policies[id]++
+	}
+}
+
+// all returns a copy of the stats of every service.
+func (c *shadowCounters) all() map[string]ShadowStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	all := map[string]ShadowStats{}
+	for service, stats := range c.stats {
+		copied := *stats
+		copied.Policies = map[string]int{}
+		for id, n := range stats.Policies {
+			copied.Policies[id] = n
+		}
+		all[service] = copied
+	}
+	return all
+}
+
+// ShadowStats returns the divergences of shadow policies, by service, since startup.
+func (doorman *LadonDoorman) ShadowStats() map[string]ShadowStats {
+	return doorman.shadowCounters.all()
+}
@@ -0,0 +1,144 @@
+package doorman
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func shadowDoorman(t *testing.T) *LadonDoorman {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{
+					ID:         "readers",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"read"},
+					Resources:  []string{"<.*>"},
+					Effect:     "allow",
+				},
+				Policy{
+					ID:         "no-contractors",
+					Principals: Principals{"group:contractors"},
+					Actions:    []string{"read"},
+					Resources:  []string{"<.*>"},
+					Effect:     "deny",
+					Mode:       ShadowMode,
+				},
+				Policy{
+					ID:         "writers",
+					Principals: Principals{"group:editors"},
+					Actions:    []string{"write"},
+					Resources:  []string{"<.*>"},
+					Effect:     "allow",
+					Mode:       ShadowMode,
+				},
+			},
+		},
+		ServiceConfig{
+			Service: "b",
+			Policies: Policies{
+				Policy{
+					ID:         "readers",
+					Principals: Principals{"<.*>"},
+					Actions:    []string{"read"},
+					Resources:  []string{"<.*>"},
+					Effect:     "allow",
+					Mode:       EnforceMode,
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+	return d
+}
+
+func TestShadowPolicies(t *testing.T) {
+	d := shadowDoorman(t)
+
+	var buf bytes.Buffer
+	d.SetAuditSinks(NewMozLogAuditSink(&buf))
+
+	// Shadow deny is not enforced.
+	decision := d.IsAllowed("a", &Request{
+		Principals: Principals{"group:contractors"},
+		Action:     "read",
+		Resource:   "doc",
+	})
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, []string{"readers"}, decision.Policies)
+	assert.Contains(t, buf.String(), "request.authorization.shadow")
+	assert.Contains(t, buf.String(), "\"shadowAllowed\":false")
+	assert.Contains(t, buf.String(), "no-contractors")
+
+	// Shadow allow is not enforced.
+	buf.Reset()
+	decision = d.IsAllowed("a", &Request{
+		Principals: Principals{"group:editors"},
+		Action:     "write",
+		Resource:   "doc",
+	})
+	assert.False(t, decision.Allowed)
+	assert.Contains(t, buf.String(), "\"shadowAllowed\":true")
+
+	// Same decision, no shadow entry.
+	buf.Reset()
+	decision = d.IsAllowed("a", &Request{
+		Principals: Principals{"group:editors"},
+		Action:     "read",
+		Resource:   "doc",
+	})
+	assert.True(t, decision.Allowed)
+	assert.NotContains(t, buf.String(), AuditShadow)
+
+	// Service without shadow policies.
+	d.IsAllowed("b", &Request{
+		Principals: Principals{"group:contractors"},
+		Action:     "write",
+		Resource:   "doc",
+	})
+	assert.NotContains(t, buf.String(), AuditShadow)
+
+	assert.Equal(t, map[string]ShadowStats{
+		"a": {
+			Divergences: 2,
+			Granted:     1,
+			Revoked:     1,
+			Policies:    map[string]int{"no-contractors": 1, "writers": 1},
+		},
+	}, d.ShadowStats())
+}
+
+func TestShadowPoliciesIgnored(t *testing.T) {
+	d := shadowDoorman(t)
+
+	// Shadow policies are not listed.
+	permissions := d.Permissions("a", &Request{
+		Principals: Principals{"group:editors"},
+		Resource:   "doc",
+	})
+	assert.Equal(t, []Permission{{Action: "read", Resource: "doc"}}, permissions)
+
+	principals := d.AllowedPrincipals("a", &Request{
+		Action:   "write",
+		Resource: "doc",
+	})
+	assert.Equal(t, Principals{}, principals)
+}
+
+func TestBadPolicyMode(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Service: "a",
+			Policies: Policies{
+				Policy{ID: "1", Mode: "dry-run"},
+			},
+		},
+	})
+	assert.Equal(t, "unknown mode \"dry-run\" in policy \"1\" (source \"\")", err.Error())
+}
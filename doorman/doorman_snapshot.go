@@ -0,0 +1,176 @@
+package doorman
+
+import (
+	"strings"
+
+	"github.com/ory/ladon"
+
+	"github.com/mozilla/doorman/authn"
+)
+
+// snapshot is the immutable state of a loaded configuration.
+// A new one is swapped in when the configuration changes, so that every
+// request is evaluated against a single consistent configuration.
+type snapshot struct {
+	services map[string]ServiceConfig
+	policies map[string]ladon.Policies
+	indexes  map[string]policyIndex
+	// shadows index the enforced and shadow policies of the services that have some.
+	shadows        map[string]policyIndex
+	tags           map[string]*tagIndex
+	roles          map[string]*roleIndex
+	attributes     map[string]attributeProviders
+	authenticators map[string]authn.Authenticator
+	// limits are the rate limit conditions of the policies, by service and policy ID.
+	limits map[string]map[string][]*RateLimitCondition
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		services:       map[string]ServiceConfig{},
+		policies:       map[string]ladon.Policies{},
+		indexes:        map[string]policyIndex{},
+		shadows:        map[string]policyIndex{},
+		tags:           map[string]*tagIndex{},
+		limits:         map[string]map[string][]*RateLimitCondition{},
+		roles:          map[string]*roleIndex{},
+		attributes:     map[string]attributeProviders{},
+		authenticators: map[string]authn.Authenticator{},
+	}
+}
+
+// copy returns a shallow copy of the snapshot, with its own maps.
+func (s *snapshot) copy() *snapshot {
+	c := newSnapshot()
+	for k, v := range s.services {
+		c.services[k] = v
+	}
+	for k, v := range s.policies {
+		c.policies[k] = v
+	}
+	for k, v := range s.indexes {
+		c.indexes[k] = v
+	}
+	for k, v := range s.shadows {
+		c.shadows[k] = v
+	}
+	for k, v := range s.limits {
+		c.limits[k] = v
+	}
+	for k, v := range s.tags {
+		c.tags[k] = v
+	}
+	for k, v := range s.roles {
+		c.roles[k] = v
+	}
+	for k, v := range s.attributes {
+		c.attributes[k] = v
+	}
+	for k, v := range s.authenticators {
+		c.authenticators[k] = v
+	}
+	return c
+}
+
+// decide evaluates the request against the policies of the (known) service.
+func (s *snapshot) decide(service string, principals Principals, r *ladon.Request) *Decision {
+	decision, _ := s.evaluate(s.indexes[service], service, principals, r)
+	return decision
+}
+
+// decideShadow evaluates the request as if the shadow policies of the service were
+// enforced. It returns nil if the service has no shadow policies.
+func (s *snapshot) decideShadow(service string, principals Principals, r *ladon.Request) *Decision {
+	index, ok := s.shadows[service]
+	if !ok {
+		return nil
+	}
+	decision, _ := s.evaluate(index, service, principals, r)
+	return decision
+}
+
+// evaluate returns the decision and the policies that matched the request.
+func (s *snapshot) evaluate(index policyIndex, service string, principals Principals, r *ladon.Request) (*Decision, []match) {
+	c := s.services[service]
+	if c.ResourceSeparator == "" {
+		// Evaluate the policies against the whole set of principals at once.
+		matches := index.match(principals, r)
+		return combine(c.CombiningAlgorithm, matches), matches
+	}
+
+	// With hierarchical resources, the policies of every level apply (eg. "a/b/c",
+	// "a/b" and "a") and are combined together, the most specific ones first.
+	resource := r.Resource
+	defer func() { r.Resource = resource }()
+	matches := []match{}
+	seen := map[string]bool{}
+	for _, level := range resourceLevels(resource, c.ResourceSeparator) {
+		r.Resource = level
+		for _, m := range index.match(principals, r) {
+			if seen[m.policy.GetID()] {
+				continue
+			}
+			seen[m.policy.GetID()] = true
+			matches = append(matches, m)
+		}
+	}
+	return combine(c.CombiningAlgorithm, matches), matches
+}
+
+// allow evaluates the request and records the allowed request in the rate limits of
+// the deciding policies, for the user of the request. If one of the limits was
+// reached in the meantime (ie. by a concurrent request), the recorded hits are
+// cancelled and the request is evaluated again.
+func (s *snapshot) allow(service string, principals Principals, r *ladon.Request) *Decision {
+	decision, matches := s.evaluate(s.indexes[service], service, principals, r)
+	if decision.Allowed && !s.reserve(service, principals, decision, matches, r) {
+		decision, matches = s.evaluate(s.indexes[service], service, principals, r)
+		if decision.Allowed && !s.reserve(service, principals, decision, matches, r) {
+			decision = &Decision{
+				Policies: []string{},
+				Reason:   ReasonNoMatch,
+			}
+		}
+	}
+	return decision
+}
+
+// reserve records a hit in the rate limits of the deciding policies, or none of
+// them if one of the limits is reached.
+func (s *snapshot) reserve(service string, principals Principals, decision *Decision, matches []match, r *ladon.Request) bool {
+	deciding := map[string]bool{}
+	for _, id := range decision.Policies {
+		deciding[id] = true
+	}
+	identity := rateLimitIdentity(principals)
+	at := now()
+	reserved := []*RateLimitCondition{}
+	for _, m := range matches {
+		if !deciding[m.policy.GetID()] {
+			continue
+		}
+		for _, limit := range s.limits[service][m.policy.GetID()] {
+			if !limit.reserve(identity, r.Action, at) {
+				for _, l := range reserved {
+					l.cancel(identity, r.Action, at)
+				}
+				return false
+			}
+			reserved = append(reserved, limit)
+		}
+	}
+	return true
+}
+
+// resourceLevels returns the resource followed by its ancestors.
+func resourceLevels(resource string, separator string) []string {
+	levels := []string{resource}
+	for {
+		i := strings.LastIndex(resource, separator)
+		if i <= 0 {
+			return levels
+		}
+		resource = resource[:i]
+		levels = append(levels, resource)
+	}
+}
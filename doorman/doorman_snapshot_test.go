@@ -0,0 +1,45 @@
+package doorman
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrentReload(t *testing.T) {
+	doorman := sampleDoorman()
+	// Silence audit logs.
+	var buf bytes.Buffer
+	doorman.SetAuditSinks(NewMozLogAuditSink(&buf))
+
+	service := "https://sample.yaml"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				principals := doorman.ExpandPrincipals(service, Principals{"userid:maria"})
+				decision := doorman.IsAllowed(service, &Request{
+					Principals: principals,
+					Action:     "update",
+					Resource:   "server.org/blocklist:onecrl",
+				})
+				assert.True(t, decision.Allowed)
+				doorman.Authenticator(service)
+				doorman.ConfigSources()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				doorman.LoadPolicies(sampleConfigs)
+				doorman.SetAuthenticator("https://other", nil)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,150 @@
+package doorman
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ory/ladon/compiler"
+)
+
+// tagPrefix is the prefix of the principals that designate tags.
+const tagPrefix = "tag:"
+
+// tagIndex expands principals with the tags they belong to. It is built once
+// when the policies are loaded.
+//
+// Tags can contain other tags (eg. "tag:admins" in "superusers"), and members
+// can be regular expressions like in policies (eg. "email:<.*@mozilla\.com>").
+type tagIndex struct {
+	tags Tags
+	// literals maps the literal members to the names of their tags.
+	literals map[string][]string
+	// patterns are the members written as regular expressions.
+	patterns []tagPattern
+}
+
+type tagPattern struct {
+	regexp *regexp.Regexp
+	tag    string
+}
+
+func newTagIndex(tags Tags) (*tagIndex, error) {
+	idx := &tagIndex{
+		tags:     tags,
+		literals: map[string][]string{},
+	}
+
+	// Iterate in a stable order, for tags to be expanded in a stable order.
+	names := []string{}
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+
+	for _, tag := range names {
+		for _, member := range tags[tag] {
+			if !strings.Contains(member, "<") {
+				idx.literals[member] = append(idx.literals[member], tag)
+				continue
+			}
+			r, err := compiler.CompileRegex(member, '<', '>')
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q in tag %q: %s", member, tag, err)
+			}
+			idx.patterns = append(idx.patterns, tagPattern{regexp: r, tag: tag})
+		}
+	}
+
+	// Detect cycles between nested tags.
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var visit func(path []string) error
+	visit = func(path []string) error {
+		tag := path[len(path)-1]
+		switch state[tag] {
+		case visiting:
+			return fmt.Errorf("cycle in tags %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[tag] = visiting
+		for _, member := range tags[tag] {
+			nested := strings.TrimPrefix(member, tagPrefix)
+			if _, ok := tags[nested]; ok && nested != member {
+				if err := visit(append(path, nested)); err != nil {
+					return err
+				}
+			}
+		}
+		state[tag] = visited
+		return nil
+	}
+	for _, tag := range names {
+		if err := visit([]string{tag}); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// expand returns the tags of the principals, including the tags of their tags.
+// Regular expressions are only matched against the specified principals.
+func (idx *tagIndex) expand(principals Principals) Principals {
+	result := Principals{}
+	seen := map[string]bool{}
+	for _, principal := range principals {
+		seen[principal] = true
+	}
+
+	queue := append(Principals{}, principals...)
+	add := func(tag string) {
+		prefixed := tagPrefix + tag
+		if seen[prefixed] {
+			return
+		}
+		seen[prefixed] = true
+		result = append(result, prefixed)
+		queue = append(queue, prefixed)
+	}
+
+	for i := 0; i < len(queue); i++ {
+		principal := queue[i]
+		for _, tag := range idx.literals[principal] {
+			add(tag)
+		}
+		if i >= len(principals) {
+			continue
+		}
+		for _, p := range idx.patterns {
+			if p.regexp.MatchString(principal) {
+				add(p.tag)
+			}
+		}
+	}
+	return result
+}
+
+// members returns the members of the tag, followed by the members of its nested tags.
+func (idx *tagIndex) members(tag string) Principals {
+	result := Principals{}
+	seen := map[string]bool{}
+	queue := []string{tag}
+	for i := 0; i < len(queue); i++ {
+		for _, member := range idx.tags[queue[i]] {
+			if seen[member] {
+				continue
+			}
+			seen[member] = true
+			result = append(result, member)
+			if nested := strings.TrimPrefix(member, tagPrefix); nested != member {
+				queue = append(queue, nested)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+package doorman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagsExpand(t *testing.T) {
+	idx, err := newTagIndex(Tags{
+		"superusers": Principals{"tag:admins", "userid:root"},
+		"admins":     Principals{"userid:maria", "tag:mozillians"},
+		"mozillians": Principals{"email:<.*@mozilla\\.com>"},
+		"staff":      Principals{"tag:mozillians"},
+	})
+	require.Nil(t, err)
+
+	// Literal members.
+	assert.Equal(t, Principals{"tag:superusers"}, idx.expand(Principals{"userid:root"}))
+
+	// Nested tags.
+	assert.Equal(t, Principals{"tag:admins", "tag:superusers"}, idx.expand(Principals{"userid:maria"}))
+
+	// Pattern members.
+	assert.Equal(t,
+		Principals{"tag:mozillians", "tag:admins", "tag:staff", "tag:superusers"},
+		idx.expand(Principals{"userid:ada", "email:ada@mozilla.com"}))
+	assert.Equal(t, Principals{}, idx.expand(Principals{"email:ada@mozilla.com.evil"}))
+
+	// Members of nested tags.
+	assert.Equal(t,
+		Principals{"tag:admins", "userid:root", "userid:maria", "tag:mozillians", "email:<.*@mozilla\\.com>"},
+		idx.members("superusers"))
+}
+
+func TestTagsBadDefinition(t *testing.T) {
+	// Cycle.
+	_, err := newTagIndex(Tags{
+		"a": Principals{"tag:b"},
+		"b": Principals{"tag:c", "userid:maria"},
+		"c": Principals{"tag:a"},
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "cycle in tags a -> b -> c -> a", err.Error())
+
+	// Self reference.
+	_, err = newTagIndex(Tags{
+		"a": Principals{"tag:a"},
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "cycle in tags a -> a", err.Error())
+
+	// Bad pattern.
+	_, err = newTagIndex(Tags{
+		"a": Principals{"email:<[a-z>"},
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid pattern \"email:<[a-z>\" in tag \"a\"")
+}
+
+func TestLoadPoliciesBadTags(t *testing.T) {
+	d := NewDefaultLadon()
+	err := d.LoadPolicies(ServicesConfig{
+		ServiceConfig{
+			Source:  "a.yaml",
+			Service: "a",
+			Tags: Tags{
+				"a": Principals{"tag:a"},
+			},
+		},
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "cycle in tags a -> a (source \"a.yaml\")", err.Error())
+}
+
+func TestServiceConfigGetTags(t *testing.T) {
+	c := &ServiceConfig{
+		Tags: Tags{
+			"admins":     Principals{"userid:maria", "tag:mozillians"},
+			"mozillians": Principals{"email:<.*@mozilla\\.com>"},
+		},
+	}
+	assert.Equal(t, Principals{"tag:admins"}, c.GetTags(Principals{"userid:maria"}))
+	assert.Equal(t, Principals{"tag:mozillians", "tag:admins"}, c.GetTags(Principals{"email:ada@mozilla.com"}))
+	assert.Equal(t, Principals{}, c.GetTags(Principals{"userid:ada"}))
+
+	// Invalid tags.
+	c.Tags["mozillians"] = Principals{"tag:admins"}
+	assert.Equal(t, Principals{}, c.GetTags(Principals{"userid:maria"}))
+}
@@ -8,6 +8,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"go.mozilla.org/mozlogrus"
+
+	"github.com/mozilla/doorman/api"
+	"github.com/mozilla/doorman/doorman"
 )
 
 var summaryLog logrus.Logger
@@ -36,17 +39,18 @@ func setupLogging() {
 }
 
 // HTTPLoggerMiddleware will log HTTP requests.
-func HTTPLoggerMiddleware() gin.HandlerFunc {
+func HTTPLoggerMiddleware(redactor *doorman.Redactor) gin.HandlerFunc {
 	// For release mode, we log requests in JSON with Moz format.
 	if gin.Mode() != gin.ReleaseMode {
 		// Default Gin debug log.
 		return gin.Logger()
 	}
-	return RequestSummaryLogger()
+	return RequestSummaryLogger(redactor)
 }
 
 // RequestSummaryLogger is a Gin middleware to log request summary following Mozilla Log format.
-func RequestSummaryLogger() gin.HandlerFunc {
+// The user ID is redacted like in the authorization log entries.
+func RequestSummaryLogger(redactor *doorman.Redactor) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Start timer
 		start := time.Now()
@@ -58,9 +62,15 @@ func RequestSummaryLogger() gin.HandlerFunc {
 		end := time.Now()
 		latency := end.Sub(start)
 
-		summaryLog.WithFields(
-			RequestLogFields(c.Request, c.Writer.Status(), latency),
-		).Info("")
+		fields := RequestLogFields(c.Request, c.Writer.Status(), latency)
+		// Correlate with the authorization log entries.
+		if rid := c.GetString(api.RequestIDContextKey); rid != "" {
+			fields["rid"] = rid
+		}
+		if uid := redactor.Subject(c.GetString(api.SubjectContextKey)); uid != "" {
+			fields["uid"] = uid
+		}
+		summaryLog.WithFields(fields).Info("")
 	}
 }
 
@@ -12,12 +12,15 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/mozilla/doorman/api"
+	"github.com/mozilla/doorman/doorman"
 )
 
 func TestLoggerMiddleware(t *testing.T) {
 	c, _ := gin.CreateTestContext(httptest.NewRecorder())
 	c.Request, _ = http.NewRequest("GET", "/get", nil)
-	handler := RequestSummaryLogger()
+	handler := RequestSummaryLogger(nil)
 
 	var buf bytes.Buffer
 	summaryLog.Out = &buf
@@ -27,6 +30,33 @@ func TestLoggerMiddleware(t *testing.T) {
 	summaryLog.Out = os.Stdout
 
 	assert.Contains(t, buf.String(), "\"errno\":0")
+	assert.Contains(t, buf.String(), "\"rid\":null")
+	assert.Contains(t, buf.String(), "\"uid\":null")
+
+	// Request ID and user ID are set by middlewares.
+	buf.Reset()
+	summaryLog.Out = &buf
+	c.Set(api.RequestIDContextKey, "abc")
+	c.Set(api.SubjectContextKey, "ldap|user")
+
+	handler(c)
+
+	summaryLog.Out = os.Stdout
+
+	assert.Contains(t, buf.String(), "\"rid\":\"abc\"")
+	assert.Contains(t, buf.String(), "\"uid\":\"ldap|user\"")
+
+	// User ID is redacted.
+	redactor, _ := doorman.NewRedactor([]string{"principal:userid=mask"}, nil)
+	handler = RequestSummaryLogger(redactor)
+	buf.Reset()
+	summaryLog.Out = &buf
+
+	handler(c)
+
+	summaryLog.Out = os.Stdout
+
+	assert.Contains(t, buf.String(), "\"uid\":\"***\"")
 }
 
 func TestRequestLogFields(t *testing.T) {
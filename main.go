@@ -3,12 +3,13 @@
 package main
 
 import (
+	"os"
+
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/mozilla/doorman/api"
 	"github.com/mozilla/doorman/config"
-	"github.com/mozilla/doorman/doorman"
 )
 
 func init() {
@@ -23,29 +24,42 @@ func setupRouter() (*gin.Engine, error) {
 	// Crash free (turns errors into 5XX).
 	r.Use(gin.Recovery())
 
+	// Identify requests (to correlate log entries).
+	r.Use(api.RequestIDMiddleware())
+
 	// Setup logging.
 	setupLogging()
-	r.Use(HTTPLoggerMiddleware())
-
-	// Load files (from folders, files, Github, etc.)
-	configs, err := config.Load(settings.Sources)
+	redactor, err := loadRedactor()
 	if err != nil {
 		return nil, err
 	}
+	r.Use(HTTPLoggerMiddleware(redactor))
 
-	// Load into Doorman.
-	d := doorman.NewDefaultLadon()
-	if err := d.LoadPolicies(configs); err != nil {
+	// Load files (from folders, files, Github, etc.) into Doorman.
+	d, err := loadDoorman()
+	if err != nil {
+		return nil, err
+	}
+	if err := setupAudit(d); err != nil {
 		return nil, err
 	}
 
 	// Endpoints
 	api.SetupRoutes(r, d)
+	api.SetupAdminRoutes(r, settings.AdminToken)
 
 	return r, nil
 }
 
 func main() {
+	// Command line subcommands (eg. doorman principals -service ...).
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:], os.Stdout); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
 	r, err := setupRouter()
 	if err != nil {
 		log.Fatal(err.Error())
@@ -50,6 +50,12 @@ policies:
 	settings.Sources = []string{"sample.yaml"}
 	r, err := setupRouter()
 	require.Nil(t, err)
-	assert.Equal(t, 7, len(r.Routes()))
-	assert.Equal(t, 3, len(r.RouterGroup.Handlers))
+	assert.Equal(t, 12, len(r.Routes()))
+	assert.Equal(t, 4, len(r.RouterGroup.Handlers))
+
+	// Bad redaction rules.
+	settings.AuditRedact = []string{"principal:email=hide"}
+	defer func() { settings.AuditRedact = nil }()
+	_, err = setupRouter()
+	assert.Contains(t, err.Error(), "invalid redaction rule")
 }
@@ -12,9 +12,15 @@ import (
 const DefaultPoliciesFilename string = "policies.yaml"
 
 var settings struct {
-	GithubToken string
-	Sources     []string
-	LogLevel    logrus.Level
+	GithubToken   string
+	AdminToken    string
+	Sources       []string
+	LogLevel      logrus.Level
+	RelationsFile string
+	AuditSinks    []string
+	AuditChainKey string
+	AuditRedact   []string
+	AuditHMACKey  string
 }
 
 func sources() []string {
@@ -23,10 +29,24 @@ func sources() []string {
 	if env == "" {
 		env = DefaultPoliciesFilename
 	}
-	sources := strings.Split(env, " ")
+	return splitList(env)
+}
+
+func auditSinks() []string {
+	// If AUDIT_SINKS not specified, log to stdout
+	env := os.Getenv("AUDIT_SINKS")
+	if env == "" {
+		env = "stdout"
+	}
+	return splitList(env)
+}
+
+// splitList returns the space separated values of the setting.
+func splitList(env string) []string {
+	values := strings.Split(env, " ")
 	// Filter empty strings
 	var r []string
-	for _, v := range sources {
+	for _, v := range values {
 		s := strings.TrimSpace(v)
 		if s != "" {
 			r = append(r, s)
@@ -56,6 +76,12 @@ func levelFromEnv() logrus.Level {
 
 func init() {
 	settings.GithubToken = os.Getenv("GITHUB_TOKEN")
+	settings.AdminToken = os.Getenv("ADMIN_TOKEN")
 	settings.Sources = sources()
 	settings.LogLevel = levelFromEnv()
+	settings.RelationsFile = os.Getenv("RELATIONS_FILE")
+	settings.AuditSinks = auditSinks()
+	settings.AuditChainKey = os.Getenv("AUDIT_CHAIN_KEY")
+	settings.AuditRedact = splitList(os.Getenv("AUDIT_REDACT"))
+	settings.AuditHMACKey = os.Getenv("AUDIT_HMAC_KEY")
 }
@@ -36,3 +36,11 @@ func TestSources(t *testing.T) {
 	defer os.Unsetenv("POLICIES")
 	assert.Equal(t, []string{"sample.yaml"}, sources())
 }
+
+func TestAuditSinks(t *testing.T) {
+	assert.Equal(t, []string{"stdout"}, auditSinks())
+
+	os.Setenv("AUDIT_SINKS", "stdout  file:///var/log/audit.log")
+	defer os.Unsetenv("AUDIT_SINKS")
+	assert.Equal(t, []string{"stdout", "file:///var/log/audit.log"}, auditSinks())
+}